@@ -1,127 +0,0 @@
-package main
-
-import (
-	"encoding/json"
-	"net/http"
-	"os"
-	"strings"
-	"time"
-
-	"github.com/dgrijalva/jwt-go"
-	"github.com/joho/godotenv"
-)
-
-var (
-	jwtKey          []byte
-	tokenExpiration = time.Minute * 15
-)
-
-type Claims struct {
-	UserID string `json:"user_id"`
-	jwt.StandardClaims
-}
-
-func init() {
-	err := godotenv.Load()
-	if err != nil {
-		panic("Error loading .env file")
-	}
-
-	jwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
-	if len(jwtKey) == 0 {
-		panic("JWT_SECRET_KEY not set in .env file")
-	}
-}
-
-func generateToken(userID string) (string, error) {
-	expirationTime := time.Now().Add(tokenExpiration)
-	claims := &Claims{
-		UserID: userID,
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: expirationTime.Unix(),
-		},
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
-}
-
-func refreshToken(w http.ResponseWriter, r *http.Request) {
-	tokenString := extractToken(r)
-	claims := &Claims{}
-
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-
-	if err != nil {
-		if err == jwt.ErrSignatureInvalid {
-			http.Error(w, "Invalid token signature", http.StatusUnauthorized)
-			return
-		}
-		http.Error(w, "Invalid token", http.StatusBadRequest)
-		return
-	}
-
-	if !token.Valid {
-		http.Error(w, "Invalid token", http.StatusUnauthorized)
-		return
-	}
-
-	// Check if the token is about to expire
-	if time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > 30*time.Second {
-		http.Error(w, "Token is not expired yet", http.StatusBadRequest)
-		return
-	}
-
-	// Generate a new token
-	newToken, err := generateToken(claims.UserID)
-	if err != nil {
-		http.Error(w, "Could not generate a new token", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"token": newToken})
-}
-
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		tokenString := extractToken(r)
-		if tokenString == "" {
-			http.Error(w, "Missing authorization token", http.StatusUnauthorized)
-			return
-		}
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Invalid token signature", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Invalid token", http.StatusBadRequest)
-			return
-		}
-
-		if !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
-			return
-		}
-
-		// You can now access claims.UserID in your handler
-		next.ServeHTTP(w, r)
-	}
-}
-
-func extractToken(r *http.Request) string {
-	bearerToken := r.Header.Get("Authorization")
-	strArr := strings.Split(bearerToken, " ")
-	if len(strArr) == 2 {
-		return strArr[1]
-	}
-	return ""
-}
@@ -0,0 +1,237 @@
+// Package auth issues and verifies the JWT access tokens of the API. Tokens are accepted as a
+// Bearer Authorization header or as the token cookie set by Login.
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// CookieName is the cookie carrying the access token for browsers
+const CookieName = "token"
+
+// RefreshCookieName is the cookie carrying the refresh token for browsers
+const RefreshCookieName = "refresh_token"
+
+// minSecretLength is the shortest signing secret accepted, 256 bits for HS256
+const minSecretLength = 32
+
+var (
+	// secret signs and verifies tokens; it is empty until Init succeeds
+	secret []byte
+	// accessTokenTTL is how long an issued token is valid; clients renew it with a refresh token
+	accessTokenTTL = 15 * time.Minute
+	// secureCookies marks the token cookie as HTTPS only
+	secureCookies bool
+)
+
+type contextKey string
+
+// claimsKey stores the claims of the authenticated request's token in the request context
+const claimsKey contextKey = "claims"
+
+// Claims are the claims of an access token. The subject is the user ID and the ID (jti)
+// names the token in the revocation list.
+type Claims struct {
+	TokenVersion int    `json:"ver"` // User's token version when the token was issued
+	SessionID    string `json:"sid"` // Login session the token was issued for
+	jwt.StandardClaims
+}
+
+// TokenChecker decides whether a validly signed token is still accepted, e.g. that it was
+// not revoked. It is set by the application with SetTokenChecker.
+type TokenChecker func(claims *Claims) error
+
+// checker is consulted by Middleware for every token
+var checker TokenChecker
+
+// SetTokenChecker sets the check Middleware applies to tokens after verifying them.
+func SetTokenChecker(c TokenChecker) {
+	checker = c
+}
+
+// Init reads the configuration from the environment and fails when the signing secret is
+// missing or too short. JWT_SECRET holds the secret (JWT_SECRET_KEY is read as a fallback),
+// ACCESS_TOKEN_TTL the token lifetime (default 15m) and COOKIE_SECURE whether the cookie is
+// restricted to HTTPS.
+func Init() error {
+	key := os.Getenv("JWT_SECRET")
+	if key == "" {
+		key = os.Getenv("JWT_SECRET_KEY")
+	}
+	if key == "" {
+		return fmt.Errorf("JWT_SECRET is not set")
+	}
+	if len(key) < minSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d bytes", minSecretLength)
+	}
+
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ACCESS_TOKEN_TTL %q", value)
+		}
+		accessTokenTTL = ttl
+	}
+	if value := os.Getenv("COOKIE_SECURE"); value != "" {
+		secure, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid COOKIE_SECURE %q", value)
+		}
+		secureCookies = secure
+	}
+
+	secret = []byte(key)
+	return nil
+}
+
+// IssueToken signs an access token for the user's session at their current token version and
+// returns it with its expiry.
+func IssueToken(userID uuid.UUID, tokenVersion int, sessionID uuid.UUID) (string, time.Time, error) {
+	if len(secret) == 0 {
+		return "", time.Time{}, fmt.Errorf("auth is not configured")
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
+	claims := &Claims{
+		TokenVersion: tokenVersion,
+		SessionID:    sessionID.String(),
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			Subject:   userID.String(),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: expiresAt.Unix(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseToken verifies the signature and expiry of an access token and returns its claims.
+func ParseToken(tokenString string) (*Claims, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("auth is not configured")
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Only accept the algorithm tokens are signed with
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if _, err := uuid.Parse(claims.Subject); err != nil || claims.Id == "" {
+		return nil, fmt.Errorf("invalid token")
+	}
+	if _, err := uuid.Parse(claims.SessionID); err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return claims, nil
+}
+
+// TokenFromRequest returns the token of a Bearer Authorization header, or else of the token cookie.
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if cookie, err := r.Cookie(CookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// Middleware rejects requests without a valid access token, or with one the token checker
+// refuses, and stores the token's claims in the request context for UserID and SessionID.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenString := TokenFromRequest(r)
+		if tokenString == "" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := ParseToken(tokenString)
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if checker != nil {
+			if err := checker(claims); err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		ctx := context.WithValue(r.Context(), claimsKey, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// UserID returns the ID of the user authenticated by Middleware.
+func UserID(r *http.Request) (uuid.UUID, bool) {
+	claims, ok := r.Context().Value(claimsKey).(*Claims)
+	if !ok {
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	return userID, err == nil
+}
+
+// SessionID returns the ID of the session the request was authenticated with by Middleware.
+func SessionID(r *http.Request) (uuid.UUID, bool) {
+	claims, ok := r.Context().Value(claimsKey).(*Claims)
+	if !ok {
+		return uuid.Nil, false
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	return sessionID, err == nil
+}
+
+// SetCookies stores the access and refresh tokens in their cookies until they expire.
+func SetCookies(w http.ResponseWriter, token string, expiresAt time.Time, refreshToken string, refreshExpiresAt time.Time) {
+	http.SetCookie(w, newCookie(CookieName, token, expiresAt))
+	http.SetCookie(w, newCookie(RefreshCookieName, refreshToken, refreshExpiresAt))
+}
+
+// ClearCookies removes the token cookies.
+func ClearCookies(w http.ResponseWriter) {
+	for _, name := range []string{CookieName, RefreshCookieName} {
+		cookie := newCookie(name, "", time.Unix(0, 0))
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+// newCookie builds a token cookie hidden from scripts.
+func newCookie(name, value string, expiresAt time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
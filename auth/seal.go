@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// Seal encrypts a secret that has to be stored and read back later, such as a token waiting
+// to be emailed. The key is derived from the signing secret, so sealed values can no longer be
+// opened once the secret changes.
+func Seal(plaintext string) (string, error) {
+	aead, err := sealCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value produced by Seal.
+func Open(sealed string) (string, error) {
+	aead, err := sealCipher()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid sealed value")
+	}
+	plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid sealed value")
+	}
+	return string(plaintext), nil
+}
+
+// sealCipher returns AES-256-GCM keyed with a key derived from the signing secret, so the
+// same bytes are never used both to sign and to encrypt.
+func sealCipher() (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("auth is not configured")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("seal"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	secret = []byte(strings.Repeat("s", minSecretLength))
+	defer func() { secret = nil }()
+
+	sealed, err := Seal("reset-token")
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if strings.Contains(sealed, "reset-token") {
+		t.Fatalf("sealed value %q holds the plaintext", sealed)
+	}
+	if again, _ := Seal("reset-token"); again == sealed {
+		t.Error("sealing twice gave the same value")
+	}
+	if opened, err := Open(sealed); err != nil || opened != "reset-token" {
+		t.Fatalf("Open = %q, %v, want the plaintext", opened, err)
+	}
+
+	tampered := []byte(sealed)
+	tampered[len(tampered)-1] ^= 1
+	for _, value := range []string{string(tampered), "", "not base64!", sealed[:8]} {
+		if _, err := Open(value); err == nil {
+			t.Errorf("Open(%q) accepted an invalid value", value)
+		}
+	}
+
+	secret = []byte(strings.Repeat("t", minSecretLength))
+	if _, err := Open(sealed); err == nil {
+		t.Error("Open accepted a value sealed under another secret")
+	}
+
+	secret = nil
+	if _, err := Seal("reset-token"); err == nil {
+		t.Error("Seal worked without a configured secret")
+	}
+}
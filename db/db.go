@@ -66,8 +66,25 @@ func ConnectDB() error {
 func Migrate() error {
 	models := []interface{}{
 		&models.User{},
+		&models.AppointmentSeries{},
 		&models.Appointment{},
 		&models.Booking{},
+		&models.BookingHistory{},
+		&models.BookingTransition{},
+		&models.WaitlistEntry{},
+		&models.AvailabilityRule{},
+		&models.AvailabilityOverride{},
+		&models.Job{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
+		&models.OutboxEvent{},
+		&models.OutboxDelivery{},
+		&models.OutboxCursor{},
+		&models.SearchDocument{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.TokenRevocation{},
+		&models.PasswordResetToken{},
 	}
 
 	// Drop existing tables
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/go-chi/chi/v5/middleware"
 	"github.com/joho/godotenv"
+	"github.com/m13ha/appointment_master/auth"
 	"github.com/m13ha/appointment_master/db"
+	"github.com/m13ha/appointment_master/notify"
 	routes "github.com/m13ha/appointment_master/routes"
+	services "github.com/m13ha/appointment_master/services"
 )
 
 func main() {
@@ -19,6 +22,12 @@ func main() {
 		log.Fatalf("Error loading .env file: %v", err)
 	}
 
+	// Tokens cannot be issued or verified without a signing secret
+	if err := auth.Init(); err != nil {
+		log.Fatalf("Error configuring authentication: %v", err)
+	}
+	auth.SetTokenChecker(services.CheckAccessToken)
+
 	// Get PORT from .env
 	port := os.Getenv("PORT")
 
@@ -27,25 +36,91 @@ func main() {
 		log.Fatalf("Error connecting to the database: %v", err)
 	}
 
+	// Deliver notifications with the configured drivers
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		log.Fatalf("Error configuring notifications: %v", err)
+	}
+	services.SetNotifier(notifier)
+
+	// Run reminders, notifications, webhooks, expirations and cleanups in the background
+	go services.RunJobScheduler(context.Background())
+
+	// Publish domain events to webhooks, notifications and the search index
+	go services.RunOutboxDispatcher(context.Background())
+
 	r := chi.NewRouter()
-	r.Use(middleware.Logger)
+	r.Use(routes.Logger)
 
 	// Auth routes
 	r.Post("/login", routes.Login)
 	r.Post("/logout", routes.Logout)
+	r.Post("/token/refresh", routes.RefreshToken)
+	r.Post("/password/reset", routes.RequestPasswordReset)
+	r.Post("/password/reset/confirm", routes.ConfirmPasswordReset)
 
 	// User routes
 	r.Post("/users", routes.CreateUser)
 
+	// Calendar feed, authenticated by its secret token
+	r.Get("/calendar/feed/{token}.ics", routes.GetCalendarFeed)
+
 	// Protected routes
 	r.Group(func(r chi.Router) {
-		r.Use(routes.AuthMiddleware)
+		r.Use(auth.Middleware)
+
+		// Auth routes
+		r.Post("/logout/all", routes.LogoutEverywhere)
+		r.Get("/sessions", routes.GetSessions)
+		r.Delete("/sessions/{id}", routes.DeleteSession)
+
+		// User routes
+		r.Get("/users/me", routes.GetCurrentUser)
+		r.Patch("/users/me", routes.UpdateCurrentUser)
+		r.Get("/users/me/availability/settings", routes.GetMyAvailabilitySettings)
+		r.Put("/users/me/availability/settings", routes.SetMyAvailabilitySettings)
+		r.Get("/users/{id}/availability", routes.GetUserAvailability)
+		r.Get("/availability/common", routes.FindCommonFreeTime)
 
 		// Appointment routes
 		r.Post("/appointments", routes.CreateAppointment)
+		r.Patch("/appointments/{id}", routes.UpdateAppointment)
+		r.Delete("/appointments/{id}", routes.CancelAppointment)
 		r.Get("/appointments/{id}/users", routes.GetUsersRegisteredForAppointment)
+		r.Get("/appointments/{id}/slots", routes.GetAppointmentSlots)
 		r.Get("/appointments/my", routes.GetMyCreatedAppointments)
 		r.Get("/appointments/registered", routes.GetRegisteredAppointments)
+		r.Get("/appointments/search", routes.SearchAppointments)
+
+		// Calendar export routes
+		r.Get("/appointments/my.ics", routes.GetMyCalendar)
+		r.Get("/appointments/{id}.ics", routes.GetAppointmentCalendar)
+		r.Post("/appointments/import", routes.ImportCalendar)
+		r.Post("/calendar/feed", routes.CreateCalendarFeed)
+		r.Delete("/calendar/feed", routes.RevokeCalendarFeed)
+
+		// Booking routes
+		r.Post("/appointments/{id}/bookings", routes.CreateBooking)
+		r.Get("/appointments/{id}/bookings", routes.GetAppointmentBookings)
+		r.Delete("/appointments/{id}/bookings/{bookingID}", routes.CancelBooking)
+		r.Post("/appointments/{id}/bookings/{bookingID}/reschedule", routes.RescheduleBooking)
+		r.Post("/appointments/{id}/bookings/{bookingID}/status", routes.ChangeBookingStatus)
+		r.Post("/appointments/{id}/bookings/{bookingID}/approve", routes.ApproveBooking)
+		r.Post("/appointments/{id}/bookings/{bookingID}/decline", routes.DeclineBooking)
+		r.Post("/appointments/{id}/waitlist", routes.JoinWaitlist)
+		r.Get("/appointments/{id}/waitlist", routes.GetWaitlist)
+		r.Delete("/appointments/{id}/waitlist/{entryID}", routes.LeaveWaitlist)
+		r.Get("/appointments/join/{code}", routes.GetAppointmentByCode)
+		r.Post("/appointments/join/{code}", routes.JoinAppointment)
+
+		// Webhook routes
+		r.Post("/webhooks", routes.CreateWebhook)
+		r.Get("/webhooks", routes.GetWebhooks)
+		r.Patch("/webhooks/{id}", routes.UpdateWebhook)
+		r.Delete("/webhooks/{id}", routes.DeleteWebhook)
+		r.Post("/webhooks/{id}/secret", routes.RotateWebhookSecret)
+		r.Get("/webhooks/{id}/deliveries", routes.GetWebhookDeliveries)
+		r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", routes.RedeliverWebhook)
 	})
 
 	log.Printf("Starting Server on PORT %s...", port)
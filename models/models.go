@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,12 +14,24 @@ type User struct {
 	ID             uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	Name           string         `json:"name" gorm:"not null"`
 	Email          string         `json:"email" gorm:"unique;not null"`
-	HashedPassword string         `json:"-" gorm:"not null"` // Stored hashed password, not exposed in JSON
+	HashedPassword string         `json:"-" gorm:"not null"`                       // Stored hashed password, not exposed in JSON
+	FeedTokenHash  string         `json:"-" gorm:"index"`                          // Hash of the calendar feed token, empty when revoked
+	TimeZone       string         `json:"time_zone" gorm:"not null;default:'UTC'"` // IANA zone name
+	Phone          string         `json:"phone,omitempty"`
+	NotifyVia      string         `json:"notify_via" gorm:"not null;default:'email'"` // NotifyEmail, NotifySMS or NotifyNone
+	TokenVersion   int            `json:"-" gorm:"not null;default:0"`                // Bumped to invalidate every access token issued before
 	CreatedAt      time.Time      `json:"created_at"`
 	UpdatedAt      time.Time      `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
+// Notification preferences of a user.
+const (
+	NotifyEmail = "email"
+	NotifySMS   = "sms"
+	NotifyNone  = "none"
+)
+
 // SetPassword hashes and sets the user's password
 func (u *User) SetPassword(password string) error {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -37,9 +50,20 @@ func (u *User) CheckPassword(password string) bool {
 
 // UserRequest represents the request payload for creating or updating a user.
 type UserRequest struct {
-	Name     string `json:"name" binding:"required"`
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Name      string `json:"name" binding:"required"`
+	Email     string `json:"email" binding:"required,email"`
+	Password  string `json:"password" binding:"required,min=8"`
+	TimeZone  string `json:"time_zone"`
+	Phone     string `json:"phone"`
+	NotifyVia string `json:"notify_via"`
+}
+
+// UserUpdateRequest represents the request payload for updating the authenticated user's settings.
+type UserUpdateRequest struct {
+	Name      *string `json:"name"`
+	TimeZone  *string `json:"time_zone"`
+	Phone     *string `json:"phone"`
+	NotifyVia *string `json:"notify_via"`
 }
 
 // UserResponse represents the response payload for user-related requests.
@@ -47,65 +71,336 @@ type UserResponse struct {
 	ID        uuid.UUID `json:"id"`
 	Name      string    `json:"name"`
 	Email     string    `json:"email"`
+	TimeZone  string    `json:"time_zone"`
+	Phone     string    `json:"phone,omitempty"`
+	NotifyVia string    `json:"notify_via"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// CalendarFeedResponse represents the response payload for a newly issued calendar feed token.
+type CalendarFeedResponse struct {
+	Token     string `json:"token"`
+	URL       string `json:"url"`
+	WebcalURL string `json:"webcal_url"`
+}
+
 // LoginRequest represents the request payload for user login.
 type LoginRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required"`
 }
 
+// TokenResponse represents an issued access token and the refresh token renewing it.
+type TokenResponse struct {
+	Token                 string    `json:"token"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// RefreshTokenRequest represents the request payload for renewing an access token. The refresh
+// token may be sent in the refresh_token cookie instead.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Session is a login of a user on one device. Its refresh tokens form one family, and access
+// tokens carry its ID, so ending the session stops both.
+type Session struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"` // Expiry of the latest refresh token
+	LastSeenAt time.Time  `json:"last_seen_at" gorm:"not null"`
+	EndedAt    *time.Time `json:"ended_at,omitempty" gorm:"index"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// SessionResponse represents an active session of the authenticated user.
+type SessionResponse struct {
+	ID         uuid.UUID `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"` // Whether the request was made with this session
+}
+
+// SessionTokens is what a login or refresh hands out besides the access token.
+type SessionTokens struct {
+	User             *User
+	SessionID        uuid.UUID
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+}
+
+// PasswordResetToken lets the owner of an email address set a new password once. Only the
+// hash of the token sent by email is stored.
+type PasswordResetToken struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	TokenHash string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null;index"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// PasswordResetRequest asks for a password reset email.
+type PasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+// PasswordResetConfirmRequest sets a new password with the token of a reset email.
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// TokenRevocation blocks an access token before it expires, e.g. after logout. Entries are
+// pruned once the token would have expired anyway.
+type TokenRevocation struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RefreshToken is an opaque, single-use token exchanged for a new access token. Each use
+// replaces it with a new token of the same family; presenting a used token again ends the
+// whole session, since one of its copies must have been stolen.
+type RefreshToken struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	FamilyID   uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"` // ID of the session the token belongs to
+	TokenHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt     *time.Time `json:"used_at,omitempty"`
+	ReplacedBy *uuid.UUID `json:"replaced_by,omitempty" gorm:"type:uuid"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
 // Appointment represents the appointment entity in the system.
 type Appointment struct {
+	ID               uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Title            string         `json:"title" gorm:"not null"`
+	StartTime        time.Time      `json:"start_time" gorm:"not null"`
+	EndTime          time.Time      `json:"end_time" gorm:"not null"`
+	Duration         time.Duration  `json:"duration" gorm:"not null"`
+	SlotCapacity     int            `json:"slot_capacity" gorm:"not null;default:1"`         // Attendees allowed per slot
+	Capacity         int            `json:"capacity" gorm:"not null;default:0"`              // Total attendees allowed, 0 means unlimited
+	BufferBefore     time.Duration  `json:"buffer_before" gorm:"not null;default:0"`         // Free time kept before every session
+	BufferAfter      time.Duration  `json:"buffer_after" gorm:"not null;default:0"`          // Free time kept after every session
+	MinNotice        time.Duration  `json:"min_notice" gorm:"not null;default:0"`            // How long before a slot bookings close, 0 means at its start
+	MaxAdvance       time.Duration  `json:"max_advance" gorm:"not null;default:0"`           // How far ahead bookings open, 0 means any time
+	RequiresApproval bool           `json:"requires_approval" gorm:"not null;default:false"` // Bookings stay pending until the organizer approves them
+	ApprovalTimeout  time.Duration  `json:"approval_timeout" gorm:"not null;default:0"`      // How long a booking may stay pending, 0 uses the default
+	UserID           uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
+	User             User           `json:"user" gorm:"foreignKey:UserID"`
+	AppCode          string         `json:"App_code" gorm:"unique;not null"`
+	SeriesID         *uuid.UUID     `json:"series_id,omitempty" gorm:"type:uuid;index"`
+	RecurrenceID     *time.Time     `json:"recurrence_id,omitempty"`                 // Start of the occurrence as generated by its series rule
+	Sequence         int            `json:"sequence" gorm:"not null;default:0"`      // Revision number, bumped on every change
+	TimeZone         string         `json:"time_zone" gorm:"not null;default:'UTC'"` // Organizer's IANA zone; times are stored as UTC instants
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// AppointmentRequest represents the request payload for creating or updating an appointment.
+type AppointmentRequest struct {
+	Title            string        `json:"title" binding:"required"`
+	StartTime        time.Time     `json:"start_time" binding:"required"`
+	EndTime          time.Time     `json:"end_time" binding:"required"`
+	Duration         time.Duration `json:"duration" gorm:"not null"`
+	SlotCapacity     int           `json:"slot_capacity"`
+	Capacity         int           `json:"capacity"`
+	BufferBefore     time.Duration `json:"buffer_before"`
+	BufferAfter      time.Duration `json:"buffer_after"`
+	MinNotice        time.Duration `json:"min_notice"`
+	MaxAdvance       time.Duration `json:"max_advance"`
+	RequiresApproval bool          `json:"requires_approval"`
+	ApprovalTimeout  time.Duration `json:"approval_timeout"`
+	TimeZone         string        `json:"time_zone"` // IANA zone, defaults to the organizer's zone
+	RRule            string        `json:"rrule"`     // Optional RFC 5545 recurrence rule, e.g. "FREQ=WEEKLY;BYDAY=MO;COUNT=10"
+	ExDates          []time.Time   `json:"exdates"`   // Occurrence start times excluded from the rule
+	UserID           uuid.UUID     `json:"user_id" binding:"required"`
+}
+
+// AppointmentSeries represents a recurring appointment. Each occurrence is stored as an
+// Appointment pointing back to its series.
+type AppointmentSeries struct {
 	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
-	Title     string         `json:"title" gorm:"not null"`
-	StartTime time.Time      `json:"start_time" gorm:"not null"`
-	EndTime   time.Time      `json:"end_time" gorm:"not null"`
-	Duration  time.Duration  `json:"duration" gorm:"not null"`
-	UserID    uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
-	User      User           `json:"user" gorm:"foreignKey:UserID"`
-	AppCode   string         `json:"App_code" gorm:"unique;not null"`
+	UserID    uuid.UUID      `json:"user_id" gorm:"type:uuid;not null;index"`
+	RRule     string         `json:"rrule" gorm:"not null"`
+	StartTime time.Time      `json:"start_time" gorm:"not null"`              // DTSTART of the rule
+	TimeZone  string         `json:"time_zone" gorm:"not null;default:'UTC'"` // Zone the rule is expanded in
+	ExDates   string         `json:"-" gorm:"type:text"`                      // Comma separated iCalendar UTC times
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
 }
 
-// AppointmentRequest represents the request payload for creating or updating an appointment.
-type AppointmentRequest struct {
-	Title     string        `json:"title" binding:"required"`
-	StartTime time.Time     `json:"start_time" binding:"required"`
-	EndTime   time.Time     `json:"end_time" binding:"required"`
-	Duration  time.Duration `json:"duration" gorm:"not null"`
-	UserID    uuid.UUID     `json:"user_id" binding:"required"`
+// AppointmentSeriesResponse represents the response payload for a recurring appointment.
+type AppointmentSeriesResponse struct {
+	ID          uuid.UUID             `json:"id"`
+	RRule       string                `json:"rrule"`
+	StartTime   time.Time             `json:"start_time"`
+	TimeZone    string                `json:"time_zone"`
+	ExDates     []time.Time           `json:"exdates,omitempty"`
+	Occurrences []AppointmentResponse `json:"occurrences"`
+}
+
+// AppointmentUpdateRequest represents the request payload for partially updating an appointment.
+// Only the fields that are set are changed.
+type AppointmentUpdateRequest struct {
+	Title            *string        `json:"title"`
+	StartTime        *time.Time     `json:"start_time"`
+	EndTime          *time.Time     `json:"end_time"`
+	Duration         *time.Duration `json:"duration"`
+	SlotCapacity     *int           `json:"slot_capacity"`
+	Capacity         *int           `json:"capacity"`
+	BufferBefore     *time.Duration `json:"buffer_before"`
+	BufferAfter      *time.Duration `json:"buffer_after"`
+	MinNotice        *time.Duration `json:"min_notice"`
+	MaxAdvance       *time.Duration `json:"max_advance"`
+	RequiresApproval *bool          `json:"requires_approval"`
+	ApprovalTimeout  *time.Duration `json:"approval_timeout"`
+	TimeZone         *string        `json:"time_zone"`
+}
+
+// Edit scopes for changes to an occurrence of a recurring appointment.
+const (
+	ScopeThis      = "this"
+	ScopeFollowing = "following"
+	ScopeAll       = "all"
+)
+
+// CancelRequest represents the optional request payload for cancelling an appointment or booking.
+type CancelRequest struct {
+	Reason string `json:"reason"`
 }
 
 // AppointmentResponse represents the response payload for appointment-related requests.
 type AppointmentResponse struct {
-	ID        uuid.UUID     `json:"id"`
-	Title     string        `json:"title"`
-	StartTime time.Time     `json:"start_time"`
-	EndTime   time.Time     `json:"end_time"`
-	UserID    uuid.UUID     `json:"user_id"`
-	Duration  time.Duration `json:"duration" gorm:"not null"`
-	AppCode   string        `json:"App_code" gorm:"not null"`
-	CreatedAt time.Time     `json:"created_at"`
-	UpdatedAt time.Time     `json:"updated_at"`
+	ID               uuid.UUID     `json:"id"`
+	Title            string        `json:"title"`
+	StartTime        time.Time     `json:"start_time"`
+	EndTime          time.Time     `json:"end_time"`
+	UserID           uuid.UUID     `json:"user_id"`
+	Duration         time.Duration `json:"duration" gorm:"not null"`
+	SlotCapacity     int           `json:"slot_capacity"`
+	Capacity         int           `json:"capacity"`
+	BufferBefore     time.Duration `json:"buffer_before"`
+	BufferAfter      time.Duration `json:"buffer_after"`
+	MinNotice        time.Duration `json:"min_notice"`
+	MaxAdvance       time.Duration `json:"max_advance"`
+	RequiresApproval bool          `json:"requires_approval"`
+	ApprovalTimeout  time.Duration `json:"approval_timeout"`
+	AppCode          string        `json:"App_code" gorm:"not null"`
+	TimeZone         string        `json:"time_zone"`
+	SeriesID         *uuid.UUID    `json:"series_id,omitempty"`
+	RecurrenceID     *time.Time    `json:"recurrence_id,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+// AppointmentPublicResponse represents the details of an appointment shown to anyone holding its code.
+type AppointmentPublicResponse struct {
+	ID               uuid.UUID     `json:"id"`
+	Title            string        `json:"title"`
+	StartTime        time.Time     `json:"start_time"`
+	EndTime          time.Time     `json:"end_time"`
+	Duration         time.Duration `json:"duration"`
+	SlotCapacity     int           `json:"slot_capacity"`
+	Capacity         int           `json:"capacity"`
+	MinNotice        time.Duration `json:"min_notice"`
+	MaxAdvance       time.Duration `json:"max_advance"`
+	RequiresApproval bool          `json:"requires_approval"`
+	AppCode          string        `json:"App_code"`
+	TimeZone         string        `json:"time_zone"`
+	Organizer        string        `json:"organizer"`
+}
+
+// Slot statuses reported by the slot list.
+const (
+	SlotFree   = "free"
+	SlotTaken  = "taken"
+	SlotClosed = "closed" // Outside the minimum notice and maximum advance booking window
+)
+
+// Slot represents a bookable time range of an appointment, Duration long.
+type Slot struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Booked    int       `json:"booked"`
+	Capacity  int       `json:"capacity"`
+	Status    string    `json:"status"`
 }
 
 // Booking represents a booking for an appointment.
 type Booking struct {
-	ID            uuid.UUID      `json:"id" gorm:"unique;type:uuid;primary_key;default:gen_random_uuid()"`
-	UserID        uuid.UUID      `json:"user_id" gorm:"type:uuid;not null"`
-	User          User           `json:"user" gorm:"foreignKey:UserID"`
-	AppointmentID uuid.UUID      `json:"appointment_id" gorm:"type:uuid;not null"`
-	Appointment   Appointment    `json:"appointment" gorm:"foreignKey:AppointmentID"`
-	StartTime     time.Time      `json:"start_time" gorm:"not null"`
-	EndTime       time.Time      `json:"end_time" gorm:"not null"`
-	CreatedAt     time.Time      `json:"created_at"`
-	UpdatedAt     time.Time      `json:"updated_at"`
-	DeletedAt     gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+	ID            uuid.UUID           `json:"id" gorm:"unique;type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID           `json:"user_id" gorm:"type:uuid;not null"`
+	User          User                `json:"user" gorm:"foreignKey:UserID"`
+	AppointmentID uuid.UUID           `json:"appointment_id" gorm:"type:uuid;not null"`
+	Appointment   Appointment         `json:"appointment" gorm:"foreignKey:AppointmentID"`
+	StartTime     time.Time           `json:"start_time" gorm:"not null"`
+	EndTime       time.Time           `json:"end_time" gorm:"not null"`
+	Status        string              `json:"status" gorm:"not null;default:'confirmed';index"`
+	ExpiresAt     *time.Time          `json:"expires_at,omitempty" gorm:"index"` // When a pending booking is declined automatically
+	CancelReason  string              `json:"cancel_reason,omitempty"`
+	History       []BookingHistory    `json:"history,omitempty" gorm:"foreignKey:BookingID"`
+	Transitions   []BookingTransition `json:"transitions,omitempty" gorm:"foreignKey:BookingID"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt      `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// Booking statuses. Pending bookings wait for the organizer's approval; declined,
+// cancelled, no-show and completed are final.
+const (
+	BookingPending   = "pending"
+	BookingConfirmed = "confirmed"
+	BookingDeclined  = "declined"
+	BookingCancelled = "cancelled"
+	BookingNoShow    = "no-show"
+	BookingCompleted = "completed"
+)
+
+// BookingTransition records a status change of a booking and who made it.
+type BookingTransition struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	BookingID  uuid.UUID  `json:"booking_id" gorm:"type:uuid;not null;index"`
+	FromStatus string     `json:"from_status"` // Empty for the initial status
+	ToStatus   string     `json:"to_status" gorm:"not null"`
+	ChangedBy  *uuid.UUID `json:"changed_by,omitempty" gorm:"type:uuid"` // Nil for automatic changes
+	Reason     string     `json:"reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// BookingStatusRequest represents the request payload for changing the status of a booking.
+type BookingStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// BookingHistory records the times a booking held before it was rescheduled.
+type BookingHistory struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	BookingID uuid.UUID `json:"booking_id" gorm:"type:uuid;not null;index"`
+	StartTime time.Time `json:"start_time" gorm:"not null"`
+	EndTime   time.Time `json:"end_time" gorm:"not null"`
+	ChangedBy uuid.UUID `json:"changed_by" gorm:"type:uuid;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RescheduleRequest represents the request payload for moving a booking to another slot.
+type RescheduleRequest struct {
+	StartTime time.Time `json:"start_time" binding:"required"`
+	EndTime   time.Time `json:"end_time" binding:"required"`
 }
 
 // BookingRequest represents the request payload for creating or updating a booking.
@@ -118,11 +413,356 @@ type BookingRequest struct {
 
 // BookingResponse represents the response payload for booking-related requests.
 type BookingResponse struct {
-	ID            uuid.UUID `json:"id"`
+	ID            uuid.UUID           `json:"id"`
+	UserID        uuid.UUID           `json:"user_id"`
+	AppointmentID uuid.UUID           `json:"appointment_id"`
+	StartTime     time.Time           `json:"start_time"`
+	EndTime       time.Time           `json:"end_time"`
+	Status        string              `json:"status"`
+	ExpiresAt     *time.Time          `json:"expires_at,omitempty"`
+	History       []BookingHistory    `json:"history,omitempty"`
+	Transitions   []BookingTransition `json:"transitions,omitempty"`
+	CreatedAt     time.Time           `json:"created_at"`
+	UpdatedAt     time.Time           `json:"updated_at"`
+}
+
+// WaitlistEntry represents a user waiting for a place on a full appointment slot.
+// Entries are served in join (CreatedAt) order and record the booking they were promoted to.
+type WaitlistEntry struct {
+	ID            uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID        uuid.UUID      `json:"user_id" gorm:"type:uuid;not null;index"`
+	User          User           `json:"user" gorm:"foreignKey:UserID"`
+	AppointmentID uuid.UUID      `json:"appointment_id" gorm:"type:uuid;not null;index"`
+	Appointment   Appointment    `json:"appointment" gorm:"foreignKey:AppointmentID"`
+	StartTime     time.Time      `json:"start_time" gorm:"not null"`
+	EndTime       time.Time      `json:"end_time" gorm:"not null"`
+	BookingID     *uuid.UUID     `json:"booking_id,omitempty" gorm:"type:uuid"`
+	PromotedAt    *time.Time     `json:"promoted_at,omitempty"`
+	NotifiedAt    *time.Time     `json:"notified_at,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// WaitlistRequest represents the request payload for joining the waitlist of a slot.
+type WaitlistRequest struct {
 	UserID        uuid.UUID `json:"user_id"`
 	AppointmentID uuid.UUID `json:"appointment_id"`
-	StartTime     time.Time `json:"start_time"`
-	EndTime       time.Time `json:"end_time"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
+	StartTime     time.Time `json:"start_time" binding:"required"`
+	EndTime       time.Time `json:"end_time" binding:"required"`
+}
+
+// WaitlistResponse represents the response payload for waitlist-related requests.
+type WaitlistResponse struct {
+	ID            uuid.UUID  `json:"id"`
+	UserID        uuid.UUID  `json:"user_id"`
+	AppointmentID uuid.UUID  `json:"appointment_id"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       time.Time  `json:"end_time"`
+	Position      int        `json:"position,omitempty"`
+	BookingID     *uuid.UUID `json:"booking_id,omitempty"`
+	PromotedAt    *time.Time `json:"promoted_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Import result statuses reported per calendar event.
+const (
+	ImportCreated     = "created"
+	ImportWouldCreate = "would_create"
+	ImportConflict    = "conflict"
+	ImportInvalid     = "invalid"
+	ImportSkipped     = "skipped"
+)
+
+// ImportResult represents the outcome of importing one calendar event.
+type ImportResult struct {
+	UID            string      `json:"uid"`
+	Title          string      `json:"title"`
+	StartTime      time.Time   `json:"start_time"`
+	Status         string      `json:"status"`
+	Occurrences    int         `json:"occurrences,omitempty"`
+	AppointmentIDs []uuid.UUID `json:"appointment_ids,omitempty"`
+	Error          string      `json:"error,omitempty"`
+}
+
+// ImportResponse represents the response payload of a calendar import.
+type ImportResponse struct {
+	DryRun    bool           `json:"dry_run"`
+	Created   int            `json:"created"`
+	Conflicts int            `json:"conflicts"`
+	Invalid   int            `json:"invalid"`
+	Skipped   int            `json:"skipped"`
+	Results   []ImportResult `json:"results"`
+}
+
+// AvailabilityRule is a weekly working hours window of a user, read in the user's time zone.
+// Users without rules are available at any time.
+type AvailabilityRule struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	Weekday   int       `json:"weekday" gorm:"not null"`    // 0 is Sunday
+	StartTime string    `json:"start_time" gorm:"not null"` // "HH:MM"
+	EndTime   string    `json:"end_time" gorm:"not null"`   // "HH:MM", "24:00" ends at midnight
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AvailabilityOverride replaces the weekly rules of a user on one date. An unavailable
+// override blacks out the whole day.
+type AvailabilityOverride struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID      uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"`
+	Date        string    `json:"date" gorm:"type:varchar(10);not null"` // "YYYY-MM-DD" in the user's zone
+	StartTime   string    `json:"start_time,omitempty"`
+	EndTime     string    `json:"end_time,omitempty"`
+	Unavailable bool      `json:"unavailable" gorm:"not null;default:false"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AvailabilityRuleRequest represents a weekly working hours window in an availability request.
+type AvailabilityRuleRequest struct {
+	Weekday   int    `json:"weekday"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// AvailabilityOverrideRequest represents a date specific window or blackout day in an availability request.
+type AvailabilityOverrideRequest struct {
+	Date        string `json:"date"`
+	StartTime   string `json:"start_time"`
+	EndTime     string `json:"end_time"`
+	Unavailable bool   `json:"unavailable"`
+}
+
+// AvailabilityRequest represents the request payload replacing a user's availability settings.
+type AvailabilityRequest struct {
+	Rules     []AvailabilityRuleRequest     `json:"rules"`
+	Overrides []AvailabilityOverrideRequest `json:"overrides"`
+}
+
+// AvailabilitySettingsResponse represents a user's stored availability settings.
+type AvailabilitySettingsResponse struct {
+	TimeZone  string                 `json:"time_zone"`
+	Rules     []AvailabilityRule     `json:"rules"`
+	Overrides []AvailabilityOverride `json:"overrides"`
+}
+
+// Interval is a half-open time range.
+type Interval struct {
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+// AvailabilityResponse represents the open intervals of a user within a date range.
+type AvailabilityResponse struct {
+	UserID    uuid.UUID  `json:"user_id"`
+	TimeZone  string     `json:"time_zone"`
+	Intervals []Interval `json:"intervals"`
+}
+
+// CommonFreeTimeResponse represents the intervals in which every listed user is free for at least the duration.
+type CommonFreeTimeResponse struct {
+	UserIDs   []uuid.UUID   `json:"user_ids"`
+	Duration  time.Duration `json:"duration"`
+	Intervals []Interval    `json:"intervals"`
+}
+
+// Job statuses.
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a unit of background work stored in the jobs table. Workers on any server instance
+// claim due jobs with SKIP LOCKED; failed attempts are retried with backoff until MaxAttempts.
+// Periodic jobs have an Interval and are queued again after each run.
+type Job struct {
+	ID          uuid.UUID     `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Kind        string        `json:"kind" gorm:"not null"`
+	Key         *string       `json:"key,omitempty" gorm:"uniqueIndex"` // Deduplicates jobs, e.g. one reminder per booking and offset
+	Payload     string        `json:"payload" gorm:"type:text"`         // JSON arguments of the handler
+	Status      string        `json:"status" gorm:"not null;default:'queued';index:idx_jobs_due,priority:1"`
+	RunAt       time.Time     `json:"run_at" gorm:"not null;index:idx_jobs_due,priority:2"`
+	Interval    time.Duration `json:"interval" gorm:"not null;default:0"`
+	Attempts    int           `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int           `json:"max_attempts" gorm:"not null;default:5"`
+	LastError   string        `json:"last_error,omitempty"`
+	LockedBy    string        `json:"locked_by,omitempty"`
+	LockedAt    *time.Time    `json:"locked_at,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+// Domain events, recorded in the outbox and delivered to webhooks under the same names.
+const (
+	EventAppointmentCreated   = "appointment.created"
+	EventAppointmentUpdated   = "appointment.updated"
+	EventAppointmentCancelled = "appointment.cancelled"
+	EventBookingCreated       = "booking.created"
+	EventBookingUpdated       = "booking.updated"
+	EventBookingCancelled     = "booking.cancelled"
+	EventUserCreated          = "user.created"
+	EventUserUpdated          = "user.updated"
+)
+
+// Aggregates domain events belong to.
+const (
+	AggregateAppointment = "appointment"
+	AggregateBooking     = "booking"
+	AggregateUser        = "user"
+)
+
+// OutboxEvent is a domain event written in the transaction that caused it. The dispatcher
+// hands every event to each consumer once; Position orders events by insertion.
+type OutboxEvent struct {
+	ID            uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	Position      int64      `json:"position" gorm:"autoIncrement;uniqueIndex"`
+	AggregateType string     `json:"aggregate_type" gorm:"not null"`
+	AggregateID   uuid.UUID  `json:"aggregate_id" gorm:"type:uuid;not null;index"`
+	Event         string     `json:"event" gorm:"not null"`
+	Payload       string     `json:"payload" gorm:"type:text;not null"`   // JSON event data
+	ActorID       *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid"` // User who caused the event, nil for the system
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+}
+
+// Outbox delivery statuses.
+const (
+	OutboxDone     = "done"
+	OutboxRetrying = "retrying"
+	OutboxFailed   = "failed"
+)
+
+// OutboxDelivery records what happened when an event was handed to a consumer. A done
+// delivery is written in the same transaction as the consumer's effects.
+type OutboxDelivery struct {
+	EventID   uuid.UUID  `json:"event_id" gorm:"type:uuid;primaryKey"`
+	Consumer  string     `json:"consumer" gorm:"primaryKey"`
+	Status    string     `json:"status" gorm:"not null"`
+	Attempts  int        `json:"attempts" gorm:"not null;default:0"`
+	LastError string     `json:"last_error,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// OutboxCursor is the position up to which a consumer has finished with every event, so
+// dispatching only looks at the events after it.
+type OutboxCursor struct {
+	Consumer  string    `json:"consumer" gorm:"primaryKey"`
+	Position  int64     `json:"position" gorm:"not null;default:0"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SearchDocument is the search index entry of an appointment, kept up to date from domain events.
+type SearchDocument struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primary_key"` // ID of the indexed appointment
+	Kind      string    `json:"kind" gorm:"not null"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:uuid;not null;index"` // Owner allowed to find it
+	Title     string    `json:"title" gorm:"not null"`
+	StartTime time.Time `json:"start_time"`
+	Sequence  int       `json:"sequence" gorm:"not null;default:0"` // Revision of the appointment indexed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookEndpoint is a URL a user registered to receive the events of their appointments.
+// Every delivery is signed with the endpoint's secret.
+type WebhookEndpoint struct {
+	ID        uuid.UUID      `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	UserID    uuid.UUID      `json:"user_id" gorm:"type:uuid;not null;index"`
+	URL       string         `json:"url" gorm:"not null"`
+	Events    string         `json:"events" gorm:"not null"` // Comma separated list of subscribed events
+	Secret    string         `json:"-" gorm:"not null"`      // HMAC key shared with the receiver
+	Active    bool           `json:"active" gorm:"not null;default:true"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// Webhook delivery statuses.
+const (
+	DeliveryPending   = "pending"
+	DeliveryRetrying  = "retrying"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one attempt to hand an event to an endpoint, retried until it succeeds or
+// runs out of attempts. A manual redelivery creates a new delivery of the same event.
+type WebhookDelivery struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	EndpointID     uuid.UUID  `json:"endpoint_id" gorm:"type:uuid;not null;index"`
+	EventID        uuid.UUID  `json:"event_id" gorm:"type:uuid;not null"` // Shared by every delivery of the event
+	Event          string     `json:"event" gorm:"not null"`
+	Payload        string     `json:"payload" gorm:"type:text;not null"`
+	Status         string     `json:"status" gorm:"not null;default:'pending'"`
+	Attempts       int        `json:"attempts" gorm:"not null;default:0"`
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	RedeliveryOf   *uuid.UUID `json:"redelivery_of,omitempty" gorm:"type:uuid"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// WebhookEndpointRequest represents the request payload for registering or changing a webhook endpoint.
+type WebhookEndpointRequest struct {
+	URL    *string  `json:"url"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// WebhookEndpointResponse represents a webhook endpoint. The secret is only included when it is issued.
+type WebhookEndpointResponse struct {
+	ID        uuid.UUID `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookPayload is the JSON body posted to webhook endpoints.
+type WebhookPayload struct {
+	ID        uuid.UUID       `json:"id"` // Event ID, the same for redeliveries
+	Event     string          `json:"event"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// AppointmentEventData describes an appointment in domain events.
+type AppointmentEventData struct {
+	ID        uuid.UUID  `json:"id"`
+	Title     string     `json:"title"`
+	StartTime time.Time  `json:"start_time"`
+	EndTime   time.Time  `json:"end_time"`
+	TimeZone  string     `json:"time_zone"`
+	UserID    uuid.UUID  `json:"user_id"`
+	SeriesID  *uuid.UUID `json:"series_id,omitempty"`
+	Sequence  int        `json:"sequence"`
+	Changed   []string   `json:"changed,omitempty"` // "times" and/or "title" on updates that move or rename it
+}
+
+// BookingEventData describes a booking in domain events, with its previous state on updates.
+type BookingEventData struct {
+	ID                uuid.UUID  `json:"id"`
+	AppointmentID     uuid.UUID  `json:"appointment_id"`
+	UserID            uuid.UUID  `json:"user_id"`
+	StartTime         time.Time  `json:"start_time"`
+	EndTime           time.Time  `json:"end_time"`
+	Status            string     `json:"status"`
+	PreviousStatus    string     `json:"previous_status,omitempty"`
+	PreviousStartTime *time.Time `json:"previous_start_time,omitempty"`
+	PreviousEndTime   *time.Time `json:"previous_end_time,omitempty"`
+	Reason            string     `json:"reason,omitempty"`
+}
+
+// UserEventData describes a user in domain events. Credentials are never included.
+type UserEventData struct {
+	ID        uuid.UUID `json:"id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	TimeZone  string    `json:"time_zone"`
+	NotifyVia string    `json:"notify_via"`
+	Changed   []string  `json:"changed,omitempty"`
 }
@@ -0,0 +1,132 @@
+// Package notify delivers messages to users over email, SMS or, during development, a log.
+package notify
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+)
+
+// Delivery channels a user may prefer.
+const (
+	ChannelEmail = "email"
+	ChannelSMS   = "sms"
+)
+
+// Recipient is the person a message is addressed to.
+type Recipient struct {
+	Name  string
+	Email string
+	Phone string
+}
+
+// Message is a rendered notification.
+type Message struct {
+	Channel string
+	To      Recipient
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages.
+type Notifier interface {
+	Send(msg Message) error
+}
+
+// ChannelNotifier routes each message to the notifier of its channel.
+type ChannelNotifier map[string]Notifier
+
+// Send delivers the message with the notifier registered for its channel.
+func (c ChannelNotifier) Send(msg Message) error {
+	notifier, ok := c[msg.Channel]
+	if !ok {
+		return fmt.Errorf("no notifier for channel %q", msg.Channel)
+	}
+	return notifier.Send(msg)
+}
+
+// FromEnv builds the notifier configured by the environment. NOTIFY_EMAIL_DRIVER selects
+// "smtp" (configured by the SMTP_* variables) or "log", the default. NOTIFY_SMS_DRIVER selects
+// "twilio" (configured by the TWILIO_* variables) or "log", the default. NOTIFY_LOG_FILE sends
+// logged messages to a file instead of the standard log.
+func FromEnv() (Notifier, error) {
+	logNotifier := &LogNotifier{}
+	if path := os.Getenv("NOTIFY_LOG_FILE"); path != "" {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open notification log: %w", err)
+		}
+		logNotifier.Writer = file
+	}
+
+	notifier := ChannelNotifier{ChannelEmail: logNotifier, ChannelSMS: logNotifier}
+	switch driver := os.Getenv("NOTIFY_EMAIL_DRIVER"); driver {
+	case "", "log":
+	case "smtp":
+		port, err := strconv.Atoi(getEnv("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMTP_PORT: %w", err)
+		}
+		smtpNotifier := &SMTPNotifier{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+		if smtpNotifier.Host == "" || smtpNotifier.From == "" {
+			return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM are required for the smtp driver")
+		}
+		notifier[ChannelEmail] = smtpNotifier
+	default:
+		return nil, fmt.Errorf("unknown email driver %q", driver)
+	}
+
+	switch driver := os.Getenv("NOTIFY_SMS_DRIVER"); driver {
+	case "", "log":
+	case "twilio":
+		sender := &TwilioSender{
+			AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_FROM"),
+		}
+		if sender.AccountSID == "" || sender.AuthToken == "" || sender.From == "" {
+			return nil, fmt.Errorf("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required for the twilio driver")
+		}
+		notifier[ChannelSMS] = &SMSNotifier{Sender: sender}
+	default:
+		return nil, fmt.Errorf("unknown sms driver %q", driver)
+	}
+	return notifier, nil
+}
+
+// LogNotifier writes messages to Writer, or to the standard log when it is nil.
+// It is meant for local development.
+type LogNotifier struct {
+	Writer io.Writer
+}
+
+// Send records the message.
+func (l *LogNotifier) Send(msg Message) error {
+	to := msg.To.Email
+	if msg.Channel == ChannelSMS {
+		to = msg.To.Phone
+	}
+	text := fmt.Sprintf("[%s] to %s <%s>: %s\n%s\n", msg.Channel, msg.To.Name, to, msg.Subject, msg.Body)
+	if l.Writer == nil {
+		log.Print(text)
+		return nil
+	}
+	_, err := io.WriteString(l.Writer, text+"\n")
+	return err
+}
+
+// getEnv retrieves the environment variable or returns a default value
+func getEnv(key, defaultValue string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultValue
+}
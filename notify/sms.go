@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SMSSender is implemented by SMS providers.
+type SMSSender interface {
+	SendSMS(to, text string) error
+}
+
+// SMSNotifier sends messages as text messages through an SMS provider.
+type SMSNotifier struct {
+	Sender SMSSender
+}
+
+// Send texts the subject and body of the message to the recipient's phone.
+func (s *SMSNotifier) Send(msg Message) error {
+	if msg.To.Phone == "" {
+		return fmt.Errorf("recipient has no phone number")
+	}
+	if err := s.Sender.SendSMS(msg.To.Phone, msg.Subject+": "+msg.Body); err != nil {
+		return fmt.Errorf("failed to send text message: %w", err)
+	}
+	return nil
+}
+
+// twilioAPIURL is the base URL of the Twilio REST API
+const twilioAPIURL = "https://api.twilio.com/2010-04-01"
+
+// TwilioSender sends text messages through the Twilio Messages API.
+type TwilioSender struct {
+	AccountSID string
+	AuthToken  string
+	From       string       // Sending phone number or messaging service
+	Client     *http.Client // Defaults to a client with a 10 second timeout
+}
+
+// SendSMS posts the message to Twilio.
+func (t *TwilioSender) SendSMS(to, text string) error {
+	form := url.Values{"To": {to}, "From": {t.From}, "Body": {text}}
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPIURL, url.PathEscape(t.AccountSID))
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.AccountSID, t.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := t.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("twilio answered with status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPNotifier sends email messages through an SMTP server.
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string // Authentication is skipped when empty
+	Password string
+	From     string
+}
+
+// Send emails the message to the recipient.
+func (s *SMTPNotifier) Send(msg Message) error {
+	if msg.To.Email == "" {
+		return fmt.Errorf("recipient has no email address")
+	}
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	to := msg.To.Email
+	if msg.To.Name != "" {
+		to = fmt.Sprintf("%s <%s>", mime.QEncoding.Encode("utf-8", msg.To.Name), msg.To.Email)
+	}
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	addr := fmt.Sprintf("%s:%d", s.Host, s.Port)
+	if err := smtp.SendMail(addr, auth, s.From, []string{msg.To.Email}, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
@@ -2,30 +2,26 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
 	models "github.com/m13ha/appointment_master/models"
 	services "github.com/m13ha/appointment_master/services"
 )
 
 // CreateAppointment handles creating a new appointment
 func CreateAppointment(w http.ResponseWriter, r *http.Request) {
-	// Retrieve user ID from context
-	userIDStr, ok := r.Context().Value(UserIDKey).(string)
+	userID, ok := currentUserID(r)
 	if !ok {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusInternalServerError)
-		return
-	}
-
 	var appointmentReq models.AppointmentRequest
 	if err := json.NewDecoder(r.Body).Decode(&appointmentReq); err != nil {
 		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
@@ -53,32 +49,166 @@ func CreateAppointment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	appointment, err := services.CreateAppointment(appointmentReq)
+	loc, err := viewerLocation(r)
 	if err != nil {
-		switch err.Error() {
-		case "end time cannot be before start time":
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		case "overlapping appointment exists":
-			http.Error(w, err.Error(), http.StatusConflict)
-		default:
-			http.Error(w, "Failed to create appointment", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if appointmentReq.RRule != "" {
+		series, occurrences, err := services.CreateRecurringAppointment(appointmentReq)
+		if err != nil {
+			writeAppointmentError(w, err, "Failed to create appointment")
+			return
 		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(newAppointmentSeriesResponse(series, occurrences, loc))
 		return
 	}
 
-	response := models.AppointmentResponse{
-		ID:        appointment.ID,
-		Title:     appointment.Title,
-		StartTime: appointment.StartTime,
-		EndTime:   appointment.EndTime,
-		UserID:    appointment.UserID,
-		AppCode:   appointment.AppCode,
-		CreatedAt: appointment.CreatedAt,
-		UpdatedAt: appointment.UpdatedAt,
+	appointment, err := services.CreateAppointment(appointmentReq)
+	if err != nil {
+		writeAppointmentError(w, err, "Failed to create appointment")
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(newAppointmentResponse(appointment, loc))
+}
+
+// UpdateAppointment handles partially updating an appointment owned by the authenticated user
+func UpdateAppointment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var updateReq models.AppointmentUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Occurrences of a recurring appointment can be edited alone, with the following ones, or all together
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != models.ScopeThis {
+		appointments, err := services.UpdateAppointmentSeries(chi.URLParam(r, "id"), userID, updateReq, scope)
+		if err != nil {
+			writeAppointmentError(w, err, "Failed to update appointment")
+			return
+		}
+
+		response := make([]models.AppointmentResponse, 0, len(appointments))
+		for i := range appointments {
+			response = append(response, newAppointmentResponse(&appointments[i], loc))
+		}
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	appointment, err := services.UpdateAppointment(chi.URLParam(r, "id"), userID, updateReq)
+	if err != nil {
+		writeAppointmentError(w, err, "Failed to update appointment")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newAppointmentResponse(appointment, loc))
+}
+
+// CancelAppointment handles cancelling an appointment owned by the authenticated user and all its bookings
+func CancelAppointment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The reason is optional, an empty body uses a default one
+	var cancelReq models.CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&cancelReq); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	scope := r.URL.Query().Get("scope")
+	if scope != "" && scope != models.ScopeThis {
+		err = services.CancelAppointmentSeries(chi.URLParam(r, "id"), userID, cancelReq.Reason, scope)
+	} else {
+		err = services.CancelAppointment(chi.URLParam(r, "id"), userID, cancelReq.Reason)
+	}
+	if err != nil {
+		writeAppointmentError(w, err, "Failed to cancel appointment")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeAppointmentError maps appointment service errors to HTTP responses
+func writeAppointmentError(w http.ResponseWriter, err error, fallback string) {
+	if strings.HasPrefix(err.Error(), "invalid recurrence rule") {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch err.Error() {
+	case "title is required", "end time cannot be before start time", "duration cannot be negative",
+		"duration must be at least 1 minute", "appointment has too many slots",
+		"slot capacity must be at least 1", "capacity cannot be negative",
+		"recurrence produces no occurrences", "appointment is not recurring", "invalid edit scope",
+		"invalid time zone", "buffer cannot be negative", "booking window cannot be negative",
+		"maximum advance must be greater than minimum notice", "approval timeout cannot be negative":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "appointment not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "not allowed to modify this appointment":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case "overlapping appointment exists", "outside organizer availability":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// newAppointmentResponse builds the response payload for an appointment with its times in loc
+func newAppointmentResponse(appointment *models.Appointment, loc *time.Location) models.AppointmentResponse {
+	var recurrenceID *time.Time
+	if appointment.RecurrenceID != nil {
+		local := appointment.RecurrenceID.In(loc)
+		recurrenceID = &local
+	}
+
+	return models.AppointmentResponse{
+		ID:               appointment.ID,
+		Title:            appointment.Title,
+		StartTime:        appointment.StartTime.In(loc),
+		EndTime:          appointment.EndTime.In(loc),
+		TimeZone:         appointment.TimeZone,
+		UserID:           appointment.UserID,
+		Duration:         appointment.Duration,
+		SlotCapacity:     appointment.SlotCapacity,
+		Capacity:         appointment.Capacity,
+		BufferBefore:     appointment.BufferBefore,
+		BufferAfter:      appointment.BufferAfter,
+		MinNotice:        appointment.MinNotice,
+		MaxAdvance:       appointment.MaxAdvance,
+		RequiresApproval: appointment.RequiresApproval,
+		ApprovalTimeout:  appointment.ApprovalTimeout,
+		AppCode:          appointment.AppCode,
+		SeriesID:         appointment.SeriesID,
+		RecurrenceID:     recurrenceID,
+		CreatedAt:        appointment.CreatedAt,
+		UpdatedAt:        appointment.UpdatedAt,
+	}
 }
 
 // GetUsersRegisteredForAppointment retrieves all users registered for a specific appointment
@@ -95,12 +225,76 @@ func GetUsersRegisteredForAppointment(w http.ResponseWriter, r *http.Request) {
 
 // GetMyCreatedAppointments shows all appointments created by the user
 func GetMyCreatedAppointments(w http.ResponseWriter, r *http.Request) {
-	userID := "some_user_id"
-	appointments, err := services.GetCreatedAppointments(userID)
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appointments, err := services.GetCreatedAppointments(userID.String())
 	if err != nil {
 		http.Error(w, "Failed to retrieve appointments", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(appointments)
+	response := make([]models.AppointmentResponse, 0, len(appointments))
+	for i := range appointments {
+		response = append(response, newAppointmentResponse(&appointments[i], loc))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// SearchAppointments lists the appointments of the authenticated user matching the q query parameter
+func SearchAppointments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appointments, err := services.SearchAppointments(userID, r.URL.Query().Get("q"))
+	if err != nil {
+		if err.Error() == "search query is required" {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to search appointments", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]models.AppointmentResponse, 0, len(appointments))
+	for i := range appointments {
+		response = append(response, newAppointmentResponse(&appointments[i], loc))
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// newAppointmentSeriesResponse builds the response payload for a recurring appointment and its occurrences
+func newAppointmentSeriesResponse(series *models.AppointmentSeries, occurrences []models.Appointment, loc *time.Location) models.AppointmentSeriesResponse {
+	response := models.AppointmentSeriesResponse{
+		ID:          series.ID,
+		RRule:       series.RRule,
+		StartTime:   series.StartTime.In(loc),
+		TimeZone:    series.TimeZone,
+		Occurrences: make([]models.AppointmentResponse, 0, len(occurrences)),
+	}
+	for _, exdate := range services.SeriesExDates(series) {
+		response.ExDates = append(response.ExDates, exdate.In(loc))
+	}
+	for i := range occurrences {
+		response.Occurrences = append(response.Occurrences, newAppointmentResponse(&occurrences[i], loc))
+	}
+	return response
 }
@@ -1,20 +1,18 @@
 package routes
 
 import (
-	"context"
 	"encoding/json"
+	"net"
 	"net/http"
-	"os"
-	"time"
 
-	"github.com/dgrijalva/jwt-go"
-	"github.com/m13ha/appointment_master/db"
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/auth"
 	"github.com/m13ha/appointment_master/models"
-	"golang.org/x/crypto/bcrypt"
+	services "github.com/m13ha/appointment_master/services"
 )
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
-
+// Login checks the user's credentials, starts a session for the device and issues an access token and a refresh token,
+// returned in the body and set as cookies
 func Login(w http.ResponseWriter, r *http.Request) {
 	var loginReq models.LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&loginReq); err != nil {
@@ -22,91 +20,168 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var user models.User
-	if err := db.DB.Where("email = ?", loginReq.Email).First(&user).Error; err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
-		return
-	}
-
-	if err := bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(loginReq.Password)); err != nil {
-		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	user, err := services.AuthenticateUser(loginReq.Email, loginReq.Password)
+	if err != nil {
+		if err.Error() == "invalid email or password" {
+			http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
 		return
 	}
 
-	expirationTime := time.Now().Add(24 * time.Hour)
-	claims := &jwt.StandardClaims{
-		Subject:   user.ID.String(),
-		ExpiresAt: expirationTime.Unix(),
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	tokens, err := services.StartSession(user, r.UserAgent(), clientIP(r))
 	if err != nil {
 		http.Error(w, "Could not generate token", http.StatusInternalServerError)
 		return
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"token": tokenString})
+	writeTokens(w, tokens)
 }
 
+// Logout ends the session of the request's access token or of the refresh token sent in the body or cookie,
+// and clears the token cookies
 func Logout(w http.ResponseWriter, r *http.Request) {
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   "",
-		Expires: time.Now().Add(-time.Hour), // Set expiration to the past
-		MaxAge:  -1,
-	})
+	if claims, err := auth.ParseToken(auth.TokenFromRequest(r)); err == nil {
+		if err := services.RevokeAccessToken(claims); err != nil {
+			http.Error(w, "Failed to log out", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := services.RevokeRefreshToken(refreshTokenFromRequest(r)); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	auth.ClearCookies(w)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
 }
 
-type contextKey string
+// LogoutEverywhere invalidates every access and refresh token of the authenticated user on all devices
+func LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.LogoutEverywhere(userID); err != nil {
+		if err.Error() == "user not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		return
+	}
+	auth.ClearCookies(w)
 
-const UserIDKey contextKey = "userID"
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out everywhere"})
+}
 
-func AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cookie, err := r.Cookie("token")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+// RefreshToken exchanges a refresh token, sent in the body or cookie, for a new access token and refresh token.
+// Each refresh token works once; reusing one ends the session it belongs to
+func RefreshToken(w http.ResponseWriter, r *http.Request) {
+	tokens, err := services.RotateRefreshToken(refreshTokenFromRequest(r))
+	if err != nil {
+		if err.Error() == "invalid refresh token" {
+			auth.ClearCookies(w)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		return
+	}
 
-		tokenStr := cookie.Value
-		claims := &jwt.StandardClaims{}
+	writeTokens(w, tokens)
+}
 
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
+// RequestPasswordReset emails a password reset token to the account with the given email.
+// The response is the same whether or not such an account exists
+func RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var resetReq models.PasswordResetRequest
+	if err := json.NewDecoder(r.Body).Decode(&resetReq); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
 
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
-				return
-			}
-			http.Error(w, "Bad request", http.StatusBadRequest)
+	if err := services.RequestPasswordReset(resetReq.Email); err != nil {
+		if err.Error() == "email is required" {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		http.Error(w, "Failed to request password reset", http.StatusInternalServerError)
+		return
+	}
 
-		if !token.Valid {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
-			return
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"message": "If an account exists for this email, a password reset email has been sent"})
+}
+
+// ConfirmPasswordReset sets a new password with the token of a reset email and logs the account out everywhere
+func ConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var confirmReq models.PasswordResetConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&confirmReq); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := services.ConfirmPasswordReset(confirmReq.Token, confirmReq.Password); err != nil {
+		switch err.Error() {
+		case "password is required", "password must be at least 8 characters", "password must be at most 72 bytes",
+			"invalid or expired reset token":
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
 		}
+		return
+	}
+	auth.ClearCookies(w)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password reset successfully"})
+}
 
-		// Add the user ID to the request context
-		ctx := context.WithValue(r.Context(), UserIDKey, claims.Subject)
-		next.ServeHTTP(w, r.WithContext(ctx))
+// refreshTokenFromRequest reads the refresh token from a JSON body, or else from the refresh_token cookie
+func refreshTokenFromRequest(r *http.Request) string {
+	var refreshReq models.RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&refreshReq); err == nil && refreshReq.RefreshToken != "" {
+		return refreshReq.RefreshToken
+	}
+	if cookie, err := r.Cookie(auth.RefreshCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// writeTokens issues an access token for the session and sends it with the refresh token in the body and cookies
+func writeTokens(w http.ResponseWriter, tokens *models.SessionTokens) {
+	token, expiresAt, err := auth.IssueToken(tokens.User.ID, tokens.User.TokenVersion, tokens.SessionID)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	auth.SetCookies(w, token, expiresAt, tokens.RefreshToken, tokens.RefreshExpiresAt)
+	json.NewEncoder(w).Encode(models.TokenResponse{
+		Token:                 token,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          tokens.RefreshToken,
+		RefreshTokenExpiresAt: tokens.RefreshExpiresAt,
 	})
 }
+
+// clientIP returns the address the request came from, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// currentUserID returns the authenticated user's ID stored in the request context by auth.Middleware
+func currentUserID(r *http.Request) (uuid.UUID, bool) {
+	return auth.UserID(r)
+}
@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// defaultAvailabilityRange is the range covered when no end is requested
+const defaultAvailabilityRange = 7 * 24 * time.Hour
+
+// GetMyAvailabilitySettings shows the working hours and overrides of the authenticated user
+func GetMyAvailabilitySettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	settings, err := services.GetAvailabilitySettings(userID)
+	if err != nil {
+		writeAvailabilityError(w, err, "Failed to retrieve availability")
+		return
+	}
+
+	json.NewEncoder(w).Encode(settings)
+}
+
+// SetMyAvailabilitySettings handles replacing the working hours and overrides of the authenticated user
+func SetMyAvailabilitySettings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var availabilityReq models.AvailabilityRequest
+	if err := json.NewDecoder(r.Body).Decode(&availabilityReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	settings, err := services.SetAvailability(userID, availabilityReq)
+	if err != nil {
+		writeAvailabilityError(w, err, "Failed to save availability")
+		return
+	}
+
+	json.NewEncoder(w).Encode(settings)
+}
+
+// GetUserAvailability lists the open intervals of a user between the from and to query
+// parameters, which default to the coming week. "me" names the authenticated user.
+func GetUserAvailability(w http.ResponseWriter, r *http.Request) {
+	var userID uuid.UUID
+	if id := chi.URLParam(r, "id"); id == "me" {
+		var ok bool
+		if userID, ok = currentUserID(r); !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		var err error
+		if userID, err = uuid.Parse(id); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r, loc, defaultAvailabilityRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	user, err := services.GetUser(userID)
+	if err != nil {
+		writeAvailabilityError(w, err, "Failed to retrieve availability")
+		return
+	}
+	intervals, err := services.GetAvailability(userID, from, to)
+	if err != nil {
+		writeAvailabilityError(w, err, "Failed to retrieve availability")
+		return
+	}
+
+	response := models.AvailabilityResponse{
+		UserID:    userID,
+		TimeZone:  user.TimeZone,
+		Intervals: localIntervals(intervals, loc),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// FindCommonFreeTime lists the intervals in which every user in the comma separated user_ids
+// query parameter is free for at least the given duration (e.g. "30m") between from and to
+func FindCommonFreeTime(w http.ResponseWriter, r *http.Request) {
+	var userIDs []uuid.UUID
+	for _, param := range r.URL.Query()["user_ids"] {
+		for _, id := range strings.Split(param, ",") {
+			userID, err := uuid.Parse(strings.TrimSpace(id))
+			if err != nil {
+				http.Error(w, "Invalid user ID", http.StatusBadRequest)
+				return
+			}
+			userIDs = append(userIDs, userID)
+		}
+	}
+
+	duration, err := time.ParseDuration(r.URL.Query().Get("duration"))
+	if err != nil {
+		http.Error(w, "invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := parseTimeRange(r, loc, defaultAvailabilityRange)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	intervals, err := services.FindCommonFreeTime(userIDs, from, to, duration)
+	if err != nil {
+		writeAvailabilityError(w, err, "Failed to find free time")
+		return
+	}
+
+	response := models.CommonFreeTimeResponse{
+		UserIDs:   userIDs,
+		Duration:  duration,
+		Intervals: localIntervals(intervals, loc),
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// writeAvailabilityError maps availability service errors to HTTP responses
+func writeAvailabilityError(w http.ResponseWriter, err error, fallback string) {
+	switch err.Error() {
+	case "invalid weekday", "invalid date", "invalid time of day", "availability must end after it starts",
+		"range end must be after its start", "range is too long",
+		"at least one user is required", "too many users", "duration must be positive":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "user not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// parseTimeRange reads the from and to query parameters as RFC 3339 times or dates in loc.
+// From defaults to now and to defaults to fallback after from.
+func parseTimeRange(r *http.Request, loc *time.Location, fallback time.Duration) (time.Time, time.Time, error) {
+	from := time.Now()
+	if value := r.URL.Query().Get("from"); value != "" {
+		parsed, err := parseTimeParam(value, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from")
+		}
+		from = parsed
+	}
+
+	to := from.Add(fallback)
+	if value := r.URL.Query().Get("to"); value != "" {
+		parsed, err := parseTimeParam(value, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to")
+		}
+		to = parsed
+	}
+	return from, to, nil
+}
+
+// parseTimeParam parses an RFC 3339 time, or a date meaning midnight in loc
+func parseTimeParam(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, loc)
+}
+
+// localIntervals converts the intervals to loc
+func localIntervals(intervals []models.Interval, loc *time.Location) []models.Interval {
+	local := make([]models.Interval, 0, len(intervals))
+	for _, interval := range intervals {
+		local = append(local, models.Interval{StartTime: interval.StartTime.In(loc), EndTime: interval.EndTime.In(loc)})
+	}
+	return local
+}
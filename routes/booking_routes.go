@@ -0,0 +1,353 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// CreateBooking handles registering the authenticated user for an appointment
+func CreateBooking(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	appointmentID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	var bookingReq models.BookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&bookingReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Participant and appointment come from the session and URL, never from the payload
+	bookingReq.UserID = userID
+	bookingReq.AppointmentID = appointmentID
+
+	// Validate required fields
+	var validationErrors []models.ValidationError
+	if bookingReq.StartTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "start_time", Message: "Start time is required"})
+	}
+	if bookingReq.EndTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "end_time", Message: "End time is required"})
+	}
+
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.NewValidationErrorResponse(validationErrors...))
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, err := services.CreateBooking(bookingReq)
+	if err != nil {
+		writeBookingError(w, err, "Failed to create booking")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newBookingResponse(booking, loc))
+}
+
+// GetAppointmentByCode shows the public details of an appointment identified by its share code
+func GetAppointmentByCode(w http.ResponseWriter, r *http.Request) {
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appointment, err := services.GetAppointmentByCode(chi.URLParam(r, "code"))
+	if err != nil {
+		writeBookingError(w, err, "Failed to retrieve appointment")
+		return
+	}
+
+	response := models.AppointmentPublicResponse{
+		ID:               appointment.ID,
+		Title:            appointment.Title,
+		StartTime:        appointment.StartTime.In(loc),
+		EndTime:          appointment.EndTime.In(loc),
+		TimeZone:         appointment.TimeZone,
+		Duration:         appointment.Duration,
+		SlotCapacity:     appointment.SlotCapacity,
+		Capacity:         appointment.Capacity,
+		MinNotice:        appointment.MinNotice,
+		MaxAdvance:       appointment.MaxAdvance,
+		RequiresApproval: appointment.RequiresApproval,
+		AppCode:          appointment.AppCode,
+		Organizer:        appointment.User.Name,
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// JoinAppointment handles booking the authenticated user onto an appointment by its share code
+func JoinAppointment(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The time range is optional, an empty body books the first free slot
+	var bookingReq models.BookingRequest
+	if err := json.NewDecoder(r.Body).Decode(&bookingReq); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	bookingReq.UserID = userID
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, err := services.JoinAppointment(chi.URLParam(r, "code"), bookingReq)
+	if err != nil {
+		writeBookingError(w, err, "Failed to join appointment")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newBookingResponse(booking, loc))
+}
+
+// GetAppointmentBookings lists the bookings of an appointment visible to the authenticated user
+func GetAppointmentBookings(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bookings, err := services.GetBookingsForAppointment(chi.URLParam(r, "id"), userID)
+	if err != nil {
+		writeBookingError(w, err, "Failed to retrieve bookings")
+		return
+	}
+
+	response := make([]models.BookingResponse, 0, len(bookings))
+	for i := range bookings {
+		response = append(response, newBookingResponse(&bookings[i], loc))
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// CancelBooking handles cancelling a booking
+func CancelBooking(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// The reason is optional, an empty body uses a default one
+	var cancelReq models.CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&cancelReq); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := services.CancelBooking(chi.URLParam(r, "id"), chi.URLParam(r, "bookingID"), userID, cancelReq.Reason); err != nil {
+		writeBookingError(w, err, "Failed to cancel booking")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RescheduleBooking handles moving a booking to another slot of its appointment
+func RescheduleBooking(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var rescheduleReq models.RescheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&rescheduleReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Validate required fields
+	var validationErrors []models.ValidationError
+	if rescheduleReq.StartTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "start_time", Message: "Start time is required"})
+	}
+	if rescheduleReq.EndTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "end_time", Message: "End time is required"})
+	}
+
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.NewValidationErrorResponse(validationErrors...))
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, err := services.RescheduleBooking(chi.URLParam(r, "id"), chi.URLParam(r, "bookingID"), userID, rescheduleReq)
+	if err != nil {
+		writeBookingError(w, err, "Failed to reschedule booking")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newBookingResponse(booking, loc))
+}
+
+// ChangeBookingStatus handles the organizer moving a booking to the status given in the payload
+func ChangeBookingStatus(w http.ResponseWriter, r *http.Request) {
+	var statusReq models.BookingStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&statusReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	changeBookingStatus(w, r, statusReq)
+}
+
+// ApproveBooking handles the organizer confirming a pending booking
+func ApproveBooking(w http.ResponseWriter, r *http.Request) {
+	changeBookingStatus(w, r, models.BookingStatusRequest{Status: models.BookingConfirmed})
+}
+
+// DeclineBooking handles the organizer declining a pending booking with an optional reason
+func DeclineBooking(w http.ResponseWriter, r *http.Request) {
+	var cancelReq models.CancelRequest
+	if err := json.NewDecoder(r.Body).Decode(&cancelReq); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	changeBookingStatus(w, r, models.BookingStatusRequest{Status: models.BookingDeclined, Reason: cancelReq.Reason})
+}
+
+// changeBookingStatus applies a status change to the booking named in the URL
+func changeBookingStatus(w http.ResponseWriter, r *http.Request, statusReq models.BookingStatusRequest) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	booking, err := services.ChangeBookingStatus(chi.URLParam(r, "id"), chi.URLParam(r, "bookingID"), userID, statusReq)
+	if err != nil {
+		writeBookingError(w, err, "Failed to change booking status")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newBookingResponse(booking, loc))
+}
+
+// GetAppointmentSlots lists the bookable slots of an appointment with their free/taken status
+func GetAppointmentSlots(w http.ResponseWriter, r *http.Request) {
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	slots, err := services.GetAppointmentSlots(chi.URLParam(r, "id"))
+	if err != nil {
+		writeBookingError(w, err, "Failed to retrieve slots")
+		return
+	}
+
+	for i := range slots {
+		slots[i].StartTime = slots[i].StartTime.In(loc)
+		slots[i].EndTime = slots[i].EndTime.In(loc)
+	}
+	json.NewEncoder(w).Encode(slots)
+}
+
+// writeBookingError maps booking service errors to HTTP responses
+func writeBookingError(w http.ResponseWriter, err error, fallback string) {
+	switch err.Error() {
+	case "end time must be after start time",
+		"booking must match an appointment slot", "booking notice is too short", "booking is too far in advance",
+		"invalid booking status":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "appointment not found", "booking not found", "waitlist entry not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "not allowed to cancel this booking", "not allowed to reschedule this booking",
+		"not allowed to remove this waitlist entry", "not allowed to change this booking":
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case "overlapping booking exists", "slot is full", "appointment is full", "no free slots available",
+		"slot is not full", "already on the waitlist", "booking is already in this slot",
+		"outside organizer availability", "invalid status transition", "booking is no longer active",
+		"booking has not started yet":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// newBookingResponse builds the response payload for a booking with its times in loc
+func newBookingResponse(booking *models.Booking, loc *time.Location) models.BookingResponse {
+	history := make([]models.BookingHistory, len(booking.History))
+	for i, entry := range booking.History {
+		entry.StartTime = entry.StartTime.In(loc)
+		entry.EndTime = entry.EndTime.In(loc)
+		history[i] = entry
+	}
+
+	var expiresAt *time.Time
+	if booking.ExpiresAt != nil {
+		local := booking.ExpiresAt.In(loc)
+		expiresAt = &local
+	}
+
+	return models.BookingResponse{
+		ID:            booking.ID,
+		UserID:        booking.UserID,
+		AppointmentID: booking.AppointmentID,
+		StartTime:     booking.StartTime.In(loc),
+		EndTime:       booking.EndTime.In(loc),
+		Status:        booking.Status,
+		ExpiresAt:     expiresAt,
+		History:       history,
+		Transitions:   booking.Transitions,
+		CreatedAt:     booking.CreatedAt,
+		UpdatedAt:     booking.UpdatedAt,
+	}
+}
@@ -0,0 +1,223 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// GetAppointmentCalendar exports an appointment as an iCalendar (.ics) file
+func GetAppointmentCalendar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	calendar, err := services.GetAppointmentCalendar(chi.URLParam(r, "id"), userID)
+	if err != nil {
+		switch err.Error() {
+		case "appointment not found":
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case "not allowed to view this appointment":
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Failed to export appointment", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeCalendar(w, "appointment.ics", calendar)
+}
+
+// GetMyCalendar exports the appointments created by and booked by the authenticated user as an iCalendar (.ics) file
+func GetMyCalendar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	calendar, err := services.GetUserCalendar(userID)
+	if err != nil {
+		http.Error(w, "Failed to export appointments", http.StatusInternalServerError)
+		return
+	}
+
+	writeCalendar(w, "my.ics", calendar)
+}
+
+// writeCalendar sends an iCalendar document as a downloadable file
+func writeCalendar(w http.ResponseWriter, filename, calendar string) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Write([]byte(calendar))
+}
+
+// maxImportSize limits the size of uploaded calendar files
+const maxImportSize = 10 << 20
+
+// ImportCalendar creates appointments for the authenticated user from an uploaded iCalendar file.
+// The file is sent as the "file" field of a multipart form or as a text/calendar body, and
+// ?dry_run=true reports the results without saving anything.
+func ImportCalendar(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	var data []byte
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, formErr := r.FormFile("file")
+		if formErr != nil {
+			http.Error(w, "Missing calendar file", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(r.Body)
+	}
+	if err != nil {
+		http.Error(w, "Failed to read calendar file", http.StatusBadRequest)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := services.ImportCalendar(userID, string(data), dryRun)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "invalid calendar") {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to import calendar", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range response.Results {
+		response.Results[i].StartTime = response.Results[i].StartTime.In(loc)
+	}
+
+	if !dryRun && response.Created > 0 {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateCalendarFeed issues a new secret calendar feed URL for the authenticated user, replacing any previous one
+func CreateCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	base, err := publicBaseURL()
+	if err != nil {
+		http.Error(w, "Calendar feeds are not configured", http.StatusInternalServerError)
+		return
+	}
+
+	token, err := services.RegenerateCalendarFeedToken(userID)
+	if err != nil {
+		http.Error(w, "Failed to create calendar feed", http.StatusInternalServerError)
+		return
+	}
+
+	feedURL := base.JoinPath("calendar", "feed", token+".ics")
+	webcalURL := *feedURL
+	webcalURL.Scheme = "webcal"
+	response := models.CalendarFeedResponse{
+		Token:     token,
+		URL:       feedURL.String(),
+		WebcalURL: webcalURL.String(),
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// RevokeCalendarFeed disables the authenticated user's calendar feed URL
+func RevokeCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.RevokeCalendarFeedToken(userID); err != nil {
+		http.Error(w, "Failed to revoke calendar feed", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCalendarFeed serves the calendar feed of the token in the URL. It is authenticated by the
+// token alone so calendar clients can poll it, and answers 304 when the client copy is current.
+func GetCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	calendar, err := services.GetCalendarFeed(chi.URLParam(r, "token"))
+	if err != nil {
+		if err.Error() == "feed not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to load calendar feed", http.StatusInternalServerError)
+		return
+	}
+
+	sum := sha256.Sum256([]byte(calendar))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(calendar))
+}
+
+// etagMatches reports whether an If-None-Match header matches the entity tag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// publicBaseURL returns the externally visible base URL from PUBLIC_URL, which must be an
+// absolute http(s) URL. The request's Host header is not used since clients control it
+func publicBaseURL() (*url.URL, error) {
+	base, err := url.Parse(os.Getenv("PUBLIC_URL"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("PUBLIC_URL must be an absolute http(s) URL")
+	}
+	return base, nil
+}
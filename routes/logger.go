@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// calendarFeedPrefix starts the paths of calendar feeds, which carry their secret token
+const calendarFeedPrefix = "/calendar/feed/"
+
+// Logger logs each request like chi's middleware.Logger, with the secret tokens of calendar
+// feed URLs left out
+var Logger = middleware.RequestLogger(&redactingLogFormatter{
+	LogFormatter: &middleware.DefaultLogFormatter{
+		Logger:  log.New(os.Stdout, "", log.LstdFlags),
+		NoColor: !isTerminal(os.Stdout),
+	},
+})
+
+// redactingLogFormatter hides secrets in request URLs before they are logged
+type redactingLogFormatter struct {
+	middleware.LogFormatter
+}
+
+// NewLogEntry creates the log entry for the request with its secrets replaced
+func (f *redactingLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	if strings.HasPrefix(r.URL.Path, calendarFeedPrefix) && r.URL.Path != calendarFeedPrefix {
+		redacted := r.Clone(r.Context())
+		redacted.URL.Path = calendarFeedPrefix + "REDACTED.ics"
+		redacted.URL.RawPath = ""
+		redacted.URL.RawQuery = ""
+		redacted.RequestURI = redacted.URL.RequestURI()
+		r = redacted
+	}
+	return f.LogFormatter.NewLogEntry(r)
+}
+
+// isTerminal reports whether the file is a terminal, where log lines may be colored
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,60 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/m13ha/appointment_master/auth"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// GetSessions lists the active login sessions of the authenticated user, marking the one the request was made with
+func GetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	sessions, err := services.GetSessions(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve sessions", http.StatusInternalServerError)
+		return
+	}
+
+	currentSessionID, _ := auth.SessionID(r)
+	responses := make([]models.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, models.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == currentSessionID,
+		})
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+// DeleteSession terminates a session of the authenticated user, logging that device out
+func DeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.EndSession(chi.URLParam(r, "id"), userID); err != nil {
+		if err.Error() == "session not found" {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to end session", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,33 @@
+package routes
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	services "github.com/m13ha/appointment_master/services"
+	"github.com/m13ha/appointment_master/utils"
+)
+
+// viewerLocation resolves the time zone response times are rendered in: the tz query parameter,
+// then a tz parameter of the Accept header (e.g. "application/json; tz=Europe/Paris"), then the
+// authenticated user's zone, and finally UTC
+func viewerLocation(r *http.Request) (*time.Location, error) {
+	if name := r.URL.Query().Get("tz"); name != "" {
+		return utils.LoadTimeZone(name)
+	}
+
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange)); err == nil && params["tz"] != "" {
+			return utils.LoadTimeZone(params["tz"])
+		}
+	}
+
+	if userID, ok := currentUserID(r); ok {
+		if user, err := services.GetUser(userID); err == nil {
+			return utils.LoadTimeZone(user.TimeZone)
+		}
+	}
+	return time.UTC, nil
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	models "github.com/m13ha/appointment_master/models"
@@ -36,29 +37,115 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 
 	user, err := services.CreateUser(userReq)
 	if err != nil {
+		switch err.Error() {
+		case "invalid time zone":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.NewValidationErrorResponse(models.ValidationError{Field: "time_zone", Message: "Time zone must be an IANA name"}))
+			return
+		case "invalid notification preference", "phone is required for sms notifications":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(models.NewValidationErrorResponse(models.ValidationError{Field: "notify_via", Message: err.Error()}))
+			return
+		}
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(models.NewDatabaseErrorResponse("Failed to create user", err.Error()))
 		return
 	}
 
-	response := models.UserResponse{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// GetCurrentUser shows the profile of the authenticated user
+func GetCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(response)
+	user, err := services.GetUser(userID)
+	if err != nil {
+		writeUserError(w, err, "Failed to retrieve user")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// UpdateCurrentUser handles changing the name or time zone of the authenticated user
+func UpdateCurrentUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var updateReq models.UserUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&updateReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	user, err := services.UpdateUser(userID, updateReq)
+	if err != nil {
+		writeUserError(w, err, "Failed to update user")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newUserResponse(user))
+}
+
+// writeUserError maps user service errors to HTTP responses
+func writeUserError(w http.ResponseWriter, err error, fallback string) {
+	switch err.Error() {
+	case "name is required", "invalid time zone", "invalid notification preference",
+		"phone is required for sms notifications":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "user not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// newUserResponse builds the response payload for a user
+func newUserResponse(user *models.User) models.UserResponse {
+	return models.UserResponse{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		TimeZone:  user.TimeZone,
+		Phone:     user.Phone,
+		NotifyVia: user.NotifyVia,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+	}
 }
 
 // GetRegisteredAppointments shows appointments a user registered for
 func GetRegisteredAppointments(w http.ResponseWriter, r *http.Request) {
-	userID := "some_user_id"
-	appointments, err := services.GetRegisteredAppointments(userID)
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	appointments, err := services.GetRegisteredAppointments(userID.String())
 	if err != nil {
 		http.Error(w, "Failed to retrieve appointments", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(appointments)
+	response := make([]models.AppointmentResponse, 0, len(appointments))
+	for i := range appointments {
+		response = append(response, newAppointmentResponse(&appointments[i], loc))
+	}
+	json.NewEncoder(w).Encode(response)
 }
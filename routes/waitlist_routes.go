@@ -0,0 +1,133 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// JoinWaitlist handles adding the authenticated user to the waitlist of a full slot
+func JoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	appointmentID, err := uuid.Parse(chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, "Invalid appointment ID", http.StatusBadRequest)
+		return
+	}
+
+	var waitlistReq models.WaitlistRequest
+	if err := json.NewDecoder(r.Body).Decode(&waitlistReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	waitlistReq.UserID = userID
+	waitlistReq.AppointmentID = appointmentID
+
+	// Validate required fields
+	var validationErrors []models.ValidationError
+	if waitlistReq.StartTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "start_time", Message: "Start time is required"})
+	}
+	if waitlistReq.EndTime.IsZero() {
+		validationErrors = append(validationErrors, models.ValidationError{Field: "end_time", Message: "End time is required"})
+	}
+
+	if len(validationErrors) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(models.NewValidationErrorResponse(validationErrors...))
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := services.JoinWaitlist(waitlistReq)
+	if err != nil {
+		writeBookingError(w, err, "Failed to join waitlist")
+		return
+	}
+
+	response := newWaitlistResponse(entry, loc)
+	if position, err := services.WaitlistPosition(entry); err == nil {
+		response.Position = position
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetWaitlist lists the waitlist entries of an appointment visible to the authenticated user
+func GetWaitlist(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	loc, err := viewerLocation(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := services.GetWaitlist(chi.URLParam(r, "id"), userID)
+	if err != nil {
+		writeBookingError(w, err, "Failed to retrieve waitlist")
+		return
+	}
+
+	response := make([]models.WaitlistResponse, 0, len(entries))
+	for i := range entries {
+		entryResponse := newWaitlistResponse(&entries[i], loc)
+		if position, err := services.WaitlistPosition(&entries[i]); err == nil {
+			entryResponse.Position = position
+		}
+		response = append(response, entryResponse)
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// LeaveWaitlist handles removing a waitlist entry
+func LeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.LeaveWaitlist(chi.URLParam(r, "id"), chi.URLParam(r, "entryID"), userID); err != nil {
+		writeBookingError(w, err, "Failed to leave waitlist")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newWaitlistResponse builds the response payload for a waitlist entry with its times in loc
+func newWaitlistResponse(entry *models.WaitlistEntry, loc *time.Location) models.WaitlistResponse {
+	return models.WaitlistResponse{
+		ID:            entry.ID,
+		UserID:        entry.UserID,
+		AppointmentID: entry.AppointmentID,
+		StartTime:     entry.StartTime.In(loc),
+		EndTime:       entry.EndTime.In(loc),
+		BookingID:     entry.BookingID,
+		PromotedAt:    entry.PromotedAt,
+		CreatedAt:     entry.CreatedAt,
+	}
+}
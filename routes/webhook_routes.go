@@ -0,0 +1,177 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	models "github.com/m13ha/appointment_master/models"
+	services "github.com/m13ha/appointment_master/services"
+)
+
+// CreateWebhook registers a webhook endpoint for the authenticated user. The signing secret is only shown in this response
+func CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var webhookReq models.WebhookEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&webhookReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	endpoint, err := services.CreateWebhookEndpoint(userID, webhookReq)
+	if err != nil {
+		writeWebhookError(w, err, "Failed to create webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newWebhookResponse(endpoint, true))
+}
+
+// GetWebhooks lists the webhook endpoints of the authenticated user
+func GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	endpoints, err := services.GetWebhookEndpoints(userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve webhooks", http.StatusInternalServerError)
+		return
+	}
+
+	responses := make([]models.WebhookEndpointResponse, 0, len(endpoints))
+	for i := range endpoints {
+		responses = append(responses, newWebhookResponse(&endpoints[i], false))
+	}
+	json.NewEncoder(w).Encode(responses)
+}
+
+// UpdateWebhook handles changing the URL, events or active flag of a webhook endpoint
+func UpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var webhookReq models.WebhookEndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&webhookReq); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	endpoint, err := services.UpdateWebhookEndpoint(chi.URLParam(r, "id"), userID, webhookReq)
+	if err != nil {
+		writeWebhookError(w, err, "Failed to update webhook")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newWebhookResponse(endpoint, false))
+}
+
+// RotateWebhookSecret issues a new signing secret for a webhook endpoint, replacing the previous one
+func RotateWebhookSecret(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	endpoint, err := services.RotateWebhookSecret(chi.URLParam(r, "id"), userID)
+	if err != nil {
+		writeWebhookError(w, err, "Failed to rotate webhook secret")
+		return
+	}
+
+	json.NewEncoder(w).Encode(newWebhookResponse(endpoint, true))
+}
+
+// DeleteWebhook handles removing a webhook endpoint
+func DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := services.DeleteWebhookEndpoint(chi.URLParam(r, "id"), userID); err != nil {
+		writeWebhookError(w, err, "Failed to delete webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetWebhookDeliveries shows the delivery log of a webhook endpoint, newest first
+func GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	deliveries, err := services.GetWebhookDeliveries(chi.URLParam(r, "id"), userID)
+	if err != nil {
+		writeWebhookError(w, err, "Failed to retrieve deliveries")
+		return
+	}
+
+	json.NewEncoder(w).Encode(deliveries)
+}
+
+// RedeliverWebhook queues a past delivery to be sent again
+func RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	userID, ok := currentUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	delivery, err := services.RedeliverWebhook(chi.URLParam(r, "id"), chi.URLParam(r, "deliveryID"), userID)
+	if err != nil {
+		writeWebhookError(w, err, "Failed to redeliver webhook")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// writeWebhookError maps webhook service errors to HTTP responses
+func writeWebhookError(w http.ResponseWriter, err error, fallback string) {
+	switch err.Error() {
+	case "invalid webhook url", "invalid webhook event", "at least one event is required":
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case "webhook not found", "delivery not found":
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case "webhook is disabled":
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// newWebhookResponse builds the response for an endpoint, including its secret when asked to
+func newWebhookResponse(endpoint *models.WebhookEndpoint, withSecret bool) models.WebhookEndpointResponse {
+	response := models.WebhookEndpointResponse{
+		ID:        endpoint.ID,
+		URL:       endpoint.URL,
+		Events:    services.WebhookEvents(endpoint),
+		Active:    endpoint.Active,
+		CreatedAt: endpoint.CreatedAt,
+		UpdatedAt: endpoint.UpdatedAt,
+	}
+	if withSecret {
+		response.Secret = endpoint.Secret
+	}
+	return response
+}
@@ -1,53 +1,369 @@
 package services
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/google/uuid"
 	"github.com/m13ha/appointment_master/db"
 	models "github.com/m13ha/appointment_master/models"
 	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
 )
 
 // CreateAppointment creates a new appointment and saves it to the database.
 func CreateAppointment(req models.AppointmentRequest) (*models.Appointment, error) {
-	// Validate time range
-	if req.EndTime.Before(req.StartTime) {
-		return nil, fmt.Errorf("end time cannot be before start time")
+	return createAppointment(db.DB, req)
+}
+
+// createAppointment validates and saves a single appointment using the given connection.
+func createAppointment(tx *gorm.DB, req models.AppointmentRequest) (*models.Appointment, error) {
+	if err := defaultTimeZone(tx, &req); err != nil {
+		return nil, err
+	}
+
+	appointment := newAppointment(req)
+	if err := validateAppointment(appointment); err != nil {
+		return nil, err
+	}
+
+	// The organizer must be working and free for the whole appointment
+	if err := checkAvailability(tx, appointment.UserID, appointment.StartTime, appointment.EndTime); err != nil {
+		return nil, err
+	}
+	if err := checkAppointmentOverlap(tx, appointment); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Create(appointment).Error; err != nil {
+		return nil, fmt.Errorf("failed to create appointment: %w", err)
+	}
+	if err := recordAppointmentEvent(tx, appointment, models.EventAppointmentCreated); err != nil {
+		return nil, err
+	}
+
+	return appointment, nil
+}
+
+// UpdateAppointment applies the given changes to an appointment owned by the user. When the
+// time window or duration changes, bookings no longer matching a slot are cancelled.
+func UpdateAppointment(appointmentID string, userID uuid.UUID, req models.AppointmentUpdateRequest) (*models.Appointment, error) {
+	var appointment *models.Appointment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		appointment, err = lockOwnedAppointment(tx, appointmentID, userID)
+		if err != nil {
+			return err
+		}
+
+		return updateAppointment(tx, appointment, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return appointment, nil
+}
+
+// CancelAppointment soft deletes an appointment owned by the user, cancelling every booking
+// and waitlist entry with the given reason. A cancelled occurrence is excluded from its series.
+func CancelAppointment(appointmentID string, userID uuid.UUID, reason string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockOwnedAppointment(tx, appointmentID, userID)
+		if err != nil {
+			return err
+		}
+
+		if appointment.SeriesID != nil && appointment.RecurrenceID != nil {
+			if err := excludeFromSeries(tx, *appointment.SeriesID, *appointment.RecurrenceID); err != nil {
+				return err
+			}
+		}
+
+		return cancelAppointment(tx, appointment, reason)
+	})
+}
+
+// newAppointment builds an appointment from the request with a fresh share code.
+func newAppointment(req models.AppointmentRequest) *models.Appointment {
+	if req.SlotCapacity == 0 {
+		req.SlotCapacity = 1
+	}
+
+	return &models.Appointment{
+		Title:            req.Title,
+		StartTime:        req.StartTime.UTC(),
+		EndTime:          req.EndTime.UTC(),
+		TimeZone:         req.TimeZone,
+		UserID:           req.UserID,
+		AppCode:          utils.GenerateAppCode(),
+		Duration:         req.Duration,
+		SlotCapacity:     req.SlotCapacity,
+		Capacity:         req.Capacity,
+		BufferBefore:     req.BufferBefore,
+		BufferAfter:      req.BufferAfter,
+		MinNotice:        req.MinNotice,
+		MaxAdvance:       req.MaxAdvance,
+		RequiresApproval: req.RequiresApproval,
+		ApprovalTimeout:  req.ApprovalTimeout,
+	}
+}
+
+// defaultTimeZone fills in the organizer's time zone when the request does not name one.
+func defaultTimeZone(tx *gorm.DB, req *models.AppointmentRequest) error {
+	if req.TimeZone != "" {
+		return nil
+	}
+
+	var organizer models.User
+	if err := tx.Select("time_zone").First(&organizer, "id = ?", req.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load organizer: %w", err)
+	}
+	req.TimeZone = organizer.TimeZone
+	return nil
+}
+
+// lockOwnedAppointment locks an appointment and checks that the user is its organizer.
+func lockOwnedAppointment(tx *gorm.DB, appointmentID string, userID uuid.UUID) (*models.Appointment, error) {
+	appointment, err := lockAppointment(tx, appointmentID)
+	if err != nil {
+		return nil, err
+	}
+	if appointment.UserID != userID {
+		return nil, fmt.Errorf("not allowed to modify this appointment")
+	}
+	return appointment, nil
+}
+
+// updateAppointment applies the changes to a locked appointment. Appointments listed in
+// excludeIDs are ignored by the overlap check, which lets a series move as a whole.
+func updateAppointment(tx *gorm.DB, appointment *models.Appointment, req models.AppointmentUpdateRequest, excludeIDs ...uuid.UUID) error {
+	title := appointment.Title
+	timesChanged := applyAppointmentUpdate(appointment, req)
+	if err := validateAppointment(appointment); err != nil {
+		return err
+	}
+
+	if timesChanged {
+		if err := checkAvailability(tx, appointment.UserID, appointment.StartTime, appointment.EndTime); err != nil {
+			return err
+		}
+		if err := checkAppointmentOverlap(tx, appointment, excludeIDs...); err != nil {
+			return err
+		}
+		if err := releaseStaleSlots(tx, appointment); err != nil {
+			return err
+		}
 	}
 
-	// Check for overlapping appointments
+	// Calendar clients only pick up changes with a higher sequence
+	appointment.Sequence++
+	if err := tx.Save(appointment).Error; err != nil {
+		return fmt.Errorf("failed to update appointment: %w", err)
+	}
+
+	// Participants are told when their appointment moves or is renamed
+	var changed []string
+	if timesChanged {
+		changed = append(changed, "times")
+	}
+	if appointment.Title != title {
+		changed = append(changed, "title")
+	}
+	if err := recordAppointmentEvent(tx, appointment, models.EventAppointmentUpdated, changed...); err != nil {
+		return err
+	}
+
+	// Freed or added places go to the waitlist first
+	return promoteWaitlist(tx, appointment)
+}
+
+// cancelAppointment cancels every booking and waitlist entry of a locked appointment and
+// soft deletes it.
+func cancelAppointment(tx *gorm.DB, appointment *models.Appointment, reason string) error {
+	if reason == "" {
+		reason = "appointment cancelled"
+	}
+
+	if err := cancelBookings(tx, tx.Where("appointment_id = ?", appointment.ID), reason, &appointment.UserID); err != nil {
+		return err
+	}
+	if err := tx.Where("appointment_id = ? AND promoted_at IS NULL", appointment.ID).
+		Delete(&models.WaitlistEntry{}).Error; err != nil {
+		return fmt.Errorf("failed to clear waitlist: %w", err)
+	}
+
+	if err := tx.Delete(appointment).Error; err != nil {
+		return fmt.Errorf("failed to cancel appointment: %w", err)
+	}
+	return recordAppointmentEvent(tx, appointment, models.EventAppointmentCancelled)
+}
+
+// applyAppointmentUpdate copies the set fields of the request onto the appointment and
+// reports whether the slot layout changed.
+func applyAppointmentUpdate(appointment *models.Appointment, req models.AppointmentUpdateRequest) bool {
+	timesChanged := false
+	if req.Title != nil {
+		appointment.Title = *req.Title
+	}
+	if req.StartTime != nil && !req.StartTime.Equal(appointment.StartTime) {
+		appointment.StartTime = *req.StartTime
+		timesChanged = true
+	}
+	if req.EndTime != nil && !req.EndTime.Equal(appointment.EndTime) {
+		appointment.EndTime = *req.EndTime
+		timesChanged = true
+	}
+	if req.Duration != nil && *req.Duration != appointment.Duration {
+		appointment.Duration = *req.Duration
+		timesChanged = true
+	}
+	if req.SlotCapacity != nil {
+		appointment.SlotCapacity = *req.SlotCapacity
+	}
+	if req.Capacity != nil {
+		appointment.Capacity = *req.Capacity
+	}
+	if req.BufferBefore != nil && *req.BufferBefore != appointment.BufferBefore {
+		appointment.BufferBefore = *req.BufferBefore
+		timesChanged = true
+	}
+	if req.BufferAfter != nil && *req.BufferAfter != appointment.BufferAfter {
+		appointment.BufferAfter = *req.BufferAfter
+		timesChanged = true
+	}
+	if req.MinNotice != nil {
+		appointment.MinNotice = *req.MinNotice
+	}
+	if req.MaxAdvance != nil {
+		appointment.MaxAdvance = *req.MaxAdvance
+	}
+	if req.RequiresApproval != nil {
+		appointment.RequiresApproval = *req.RequiresApproval
+	}
+	if req.ApprovalTimeout != nil {
+		appointment.ApprovalTimeout = *req.ApprovalTimeout
+	}
+	if req.TimeZone != nil {
+		appointment.TimeZone = *req.TimeZone
+	}
+	return timesChanged
+}
+
+// validateAppointment checks the time window, duration and capacities of an appointment.
+func validateAppointment(appointment *models.Appointment) error {
+	if appointment.Title == "" {
+		return fmt.Errorf("title is required")
+	}
+	if appointment.EndTime.Before(appointment.StartTime) {
+		return fmt.Errorf("end time cannot be before start time")
+	}
+	if appointment.Duration < 0 {
+		return fmt.Errorf("duration cannot be negative")
+	}
+	if appointment.Duration > 0 && appointment.Duration < minSlotDuration {
+		return fmt.Errorf("duration must be at least 1 minute")
+	}
+	if appointment.SlotCapacity < 1 {
+		return fmt.Errorf("slot capacity must be at least 1")
+	}
+	if appointment.Capacity < 0 {
+		return fmt.Errorf("capacity cannot be negative")
+	}
+	if appointment.BufferBefore < 0 || appointment.BufferAfter < 0 {
+		return fmt.Errorf("buffer cannot be negative")
+	}
+	if slotCount(appointment) > maxSlotsPerAppointment {
+		return fmt.Errorf("appointment has too many slots")
+	}
+	if appointment.MinNotice < 0 || appointment.MaxAdvance < 0 {
+		return fmt.Errorf("booking window cannot be negative")
+	}
+	if appointment.MaxAdvance > 0 && appointment.MaxAdvance <= appointment.MinNotice {
+		return fmt.Errorf("maximum advance must be greater than minimum notice")
+	}
+	if appointment.ApprovalTimeout < 0 {
+		return fmt.Errorf("approval timeout cannot be negative")
+	}
+	if _, err := utils.LoadTimeZone(appointment.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone")
+	}
+	return nil
+}
+
+// checkAppointmentOverlap reports whether the organizer has another appointment, besides the
+// excluded ones, overlapping this one. Both appointments are widened by their buffers, and
+// appointments that merely touch count as overlapping, so back-to-back ones are rejected.
+func checkAppointmentOverlap(tx *gorm.DB, appointment *models.Appointment, excludeIDs ...uuid.UUID) error {
+	excludeIDs = append(excludeIDs, appointment.ID)
+	start := appointment.StartTime.Add(-appointment.BufferBefore)
+	end := appointment.EndTime.Add(appointment.BufferAfter)
+
+	// Buffers are stored in nanoseconds
 	var count int64
-	err := db.DB.Model(&models.Appointment{}).
-		Where("user_id = ? AND ((start_time <= ? AND end_time >= ?) OR (start_time <= ? AND end_time >= ?) OR (start_time >= ? AND end_time <= ?))",
-			req.UserID, req.StartTime, req.StartTime, req.EndTime, req.EndTime, req.StartTime, req.EndTime).
+	err := tx.Model(&models.Appointment{}).
+		Where("user_id = ? AND id NOT IN ? AND start_time - buffer_before / 1000 * INTERVAL '1 microsecond' <= ? AND end_time + buffer_after / 1000 * INTERVAL '1 microsecond' >= ?",
+			appointment.UserID, excludeIDs, end, start).
 		Count(&count).Error
 	if err != nil {
-		return nil, fmt.Errorf("failed to check for overlapping appointments: %w", err)
+		return fmt.Errorf("failed to check for overlapping appointments: %w", err)
 	}
 	if count > 0 {
-		return nil, fmt.Errorf("overlapping appointment exists")
+		return fmt.Errorf("overlapping appointment exists")
 	}
+	return nil
+}
 
-	appointment := &models.Appointment{
-		Title:     req.Title,
-		StartTime: req.StartTime,
-		EndTime:   req.EndTime,
-		UserID:    req.UserID,
-		AppCode:   utils.GenerateAppCode(),
-		Duration:  req.Duration,
-	}
+// releaseStaleSlots cancels bookings and drops waitlist entries that no longer match a slot
+// of the appointment after its time window or duration changed. A booking only matches when
+// both its start and end are those of the same slot.
+func releaseStaleSlots(tx *gorm.DB, appointment *models.Appointment) error {
+	valid := slotKeys(GenerateSlots(appointment))
 
-	if err := db.DB.Create(appointment).Error; err != nil {
-		return nil, fmt.Errorf("failed to create appointment: %w", err)
+	var bookings []models.Booking
+	if err := tx.Select("id, start_time, end_time").Where("appointment_id = ?", appointment.ID).
+		Find(&bookings).Error; err != nil {
+		return fmt.Errorf("failed to load bookings: %w", err)
+	}
+	var staleBookings []uuid.UUID
+	for _, booking := range bookings {
+		if !valid[newSlotKey(booking.StartTime, booking.EndTime)] {
+			staleBookings = append(staleBookings, booking.ID)
+		}
+	}
+	if len(staleBookings) > 0 {
+		if err := cancelBookings(tx, tx.Where("id IN ?", staleBookings), "appointment time changed", &appointment.UserID); err != nil {
+			return err
+		}
 	}
 
-	return appointment, nil
+	var entries []models.WaitlistEntry
+	if err := tx.Select("id, start_time, end_time").Where("appointment_id = ? AND promoted_at IS NULL", appointment.ID).
+		Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to load waitlist: %w", err)
+	}
+	var staleEntries []uuid.UUID
+	for _, entry := range entries {
+		if !valid[newSlotKey(entry.StartTime, entry.EndTime)] {
+			staleEntries = append(staleEntries, entry.ID)
+		}
+	}
+	if len(staleEntries) > 0 {
+		if err := tx.Where("id IN ?", staleEntries).Delete(&models.WaitlistEntry{}).Error; err != nil {
+			return fmt.Errorf("failed to clear waitlist: %w", err)
+		}
+	}
+	return nil
 }
 
 // GetUsersForAppointment retrieves users registered for a specific appointment.
 func GetUsersForAppointment(appointmentID string) ([]models.User, error) {
 	var users []models.User
-	if err := db.DB.Where("appointment_id = ?", appointmentID).Find(&users).Error; err != nil {
+	if err := db.DB.Distinct("users.*").
+		Joins("JOIN bookings ON bookings.user_id = users.id AND bookings.deleted_at IS NULL").
+		Where("bookings.appointment_id = ?", appointmentID).
+		Find(&users).Error; err != nil {
 		return nil, err
 	}
 	return users, nil
@@ -61,3 +377,17 @@ func GetCreatedAppointments(userID string) ([]models.Appointment, error) {
 	}
 	return appointments, nil
 }
+
+// GetAppointmentByCode retrieves an appointment, with its organizer, by its share code.
+func GetAppointmentByCode(code string) (*models.Appointment, error) {
+	var appointment models.Appointment
+	if err := db.DB.Preload("User").
+		Where("app_code = ?", strings.ToUpper(strings.TrimSpace(code))).
+		First(&appointment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		return nil, err
+	}
+	return &appointment, nil
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	// availabilityDateLayout is the format of override dates
+	availabilityDateLayout = "2006-01-02"
+	// maxAvailabilityRange bounds the range open intervals are computed for
+	maxAvailabilityRange = 92 * 24 * time.Hour
+	// minutesPerDay is the end of a day as a time of day
+	minutesPerDay = 24 * 60
+)
+
+// availability holds the working hours of a user, loaded once to check many ranges.
+type availability struct {
+	loc       *time.Location
+	rules     []models.AvailabilityRule
+	overrides map[string][]models.AvailabilityOverride
+}
+
+// SetAvailability replaces the weekly rules and date overrides of the user. Times of day
+// are read in the user's time zone.
+func SetAvailability(userID uuid.UUID, req models.AvailabilityRequest) (*models.AvailabilitySettingsResponse, error) {
+	rules := make([]models.AvailabilityRule, 0, len(req.Rules))
+	for _, ruleReq := range req.Rules {
+		if ruleReq.Weekday < 0 || ruleReq.Weekday > 6 {
+			return nil, fmt.Errorf("invalid weekday")
+		}
+		if err := validateWindow(ruleReq.StartTime, ruleReq.EndTime); err != nil {
+			return nil, err
+		}
+		rules = append(rules, models.AvailabilityRule{
+			UserID:    userID,
+			Weekday:   ruleReq.Weekday,
+			StartTime: ruleReq.StartTime,
+			EndTime:   ruleReq.EndTime,
+		})
+	}
+
+	overrides := make([]models.AvailabilityOverride, 0, len(req.Overrides))
+	for _, overrideReq := range req.Overrides {
+		if _, err := time.Parse(availabilityDateLayout, overrideReq.Date); err != nil {
+			return nil, fmt.Errorf("invalid date")
+		}
+		override := models.AvailabilityOverride{
+			UserID:      userID,
+			Date:        overrideReq.Date,
+			Unavailable: overrideReq.Unavailable,
+		}
+		if !overrideReq.Unavailable {
+			if err := validateWindow(overrideReq.StartTime, overrideReq.EndTime); err != nil {
+				return nil, err
+			}
+			override.StartTime = overrideReq.StartTime
+			override.EndTime = overrideReq.EndTime
+		}
+		overrides = append(overrides, override)
+	}
+
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.AvailabilityRule{}).Error; err != nil {
+			return fmt.Errorf("failed to clear availability rules: %w", err)
+		}
+		if err := tx.Where("user_id = ?", userID).Delete(&models.AvailabilityOverride{}).Error; err != nil {
+			return fmt.Errorf("failed to clear availability overrides: %w", err)
+		}
+		if len(rules) > 0 {
+			if err := tx.Create(&rules).Error; err != nil {
+				return fmt.Errorf("failed to save availability rules: %w", err)
+			}
+		}
+		if len(overrides) > 0 {
+			if err := tx.Create(&overrides).Error; err != nil {
+				return fmt.Errorf("failed to save availability overrides: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return GetAvailabilitySettings(userID)
+}
+
+// GetAvailabilitySettings retrieves the weekly rules and date overrides of the user.
+func GetAvailabilitySettings(userID uuid.UUID) (*models.AvailabilitySettingsResponse, error) {
+	user, err := GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &models.AvailabilitySettingsResponse{TimeZone: user.TimeZone}
+	if err := db.DB.Where("user_id = ?", userID).Order("weekday, start_time").
+		Find(&settings.Rules).Error; err != nil {
+		return nil, err
+	}
+	if err := db.DB.Where("user_id = ?", userID).Order("date, start_time").
+		Find(&settings.Overrides).Error; err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// GetAvailability computes the intervals within [from, to) in which the user is available
+// according to their working hours.
+func GetAvailability(userID uuid.UUID, from, to time.Time) ([]models.Interval, error) {
+	if !to.After(from) {
+		return nil, fmt.Errorf("range end must be after its start")
+	}
+	if to.Sub(from) > maxAvailabilityRange {
+		return nil, fmt.Errorf("range is too long")
+	}
+	if _, err := GetUser(userID); err != nil {
+		return nil, err
+	}
+
+	hours, err := loadAvailability(db.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return hours.intervals(from, to), nil
+}
+
+// checkAvailability reports whether [start, end) falls outside the working hours of the user.
+func checkAvailability(tx *gorm.DB, userID uuid.UUID, start, end time.Time) error {
+	hours, err := loadAvailability(tx, userID)
+	if err != nil {
+		return err
+	}
+	if !hours.covers(start, end) {
+		return fmt.Errorf("outside organizer availability")
+	}
+	return nil
+}
+
+// loadAvailability loads the time zone, weekly rules and overrides of the user.
+func loadAvailability(tx *gorm.DB, userID uuid.UUID) (*availability, error) {
+	var user models.User
+	if err := tx.Select("time_zone").First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+	loc, err := utils.LoadTimeZone(user.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	hours := &availability{loc: loc, overrides: make(map[string][]models.AvailabilityOverride)}
+	if err := tx.Where("user_id = ?", userID).Find(&hours.rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to load availability rules: %w", err)
+	}
+
+	var overrides []models.AvailabilityOverride
+	if err := tx.Where("user_id = ?", userID).Find(&overrides).Error; err != nil {
+		return nil, fmt.Errorf("failed to load availability overrides: %w", err)
+	}
+	for _, override := range overrides {
+		hours.overrides[override.Date] = append(hours.overrides[override.Date], override)
+	}
+	return hours, nil
+}
+
+// covers reports whether [start, end) lies within a single open interval. An empty range
+// must fall on an open instant.
+func (a *availability) covers(start, end time.Time) bool {
+	if !end.After(start) {
+		end = start.Add(time.Nanosecond)
+	}
+	intervals := a.intervals(start, end)
+	return len(intervals) == 1 && intervals[0].StartTime.Equal(start) && intervals[0].EndTime.Equal(end)
+}
+
+// intervals returns the merged open intervals within [from, to), in UTC.
+func (a *availability) intervals(from, to time.Time) []models.Interval {
+	var intervals []models.Interval
+	first := from.In(a.loc)
+	last := to.In(a.loc)
+	for day := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, a.loc); day.Before(last); day = day.AddDate(0, 0, 1) {
+		for _, window := range a.dayWindows(day) {
+			start := time.Date(day.Year(), day.Month(), day.Day(), 0, window[0], 0, 0, a.loc)
+			end := time.Date(day.Year(), day.Month(), day.Day(), 0, window[1], 0, 0, a.loc)
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if end.After(start) {
+				intervals = append(intervals, models.Interval{StartTime: start.UTC(), EndTime: end.UTC()})
+			}
+		}
+	}
+	return mergeIntervals(intervals)
+}
+
+// dayWindows returns the open windows of a day as minutes since midnight. Overrides for the
+// date take precedence over the weekly rules.
+func (a *availability) dayWindows(day time.Time) [][2]int {
+	var windows [][2]int
+	if overrides, ok := a.overrides[day.Format(availabilityDateLayout)]; ok {
+		for _, override := range overrides {
+			if override.Unavailable {
+				return nil
+			}
+			windows = append(windows, clockWindow(override.StartTime, override.EndTime))
+		}
+		return windows
+	}
+
+	if len(a.rules) == 0 {
+		return [][2]int{{0, minutesPerDay}}
+	}
+	for _, rule := range a.rules {
+		if time.Weekday(rule.Weekday) == day.Weekday() {
+			windows = append(windows, clockWindow(rule.StartTime, rule.EndTime))
+		}
+	}
+	return windows
+}
+
+// mergeIntervals sorts the intervals and joins the ones that overlap or touch.
+func mergeIntervals(intervals []models.Interval) []models.Interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].StartTime.Before(intervals[j].StartTime) })
+
+	var merged []models.Interval
+	for _, interval := range intervals {
+		if n := len(merged); n > 0 && !interval.StartTime.After(merged[n-1].EndTime) {
+			if interval.EndTime.After(merged[n-1].EndTime) {
+				merged[n-1].EndTime = interval.EndTime
+			}
+			continue
+		}
+		merged = append(merged, interval)
+	}
+	return merged
+}
+
+// validateWindow checks that a window has valid times of day and ends after it starts.
+func validateWindow(start, end string) error {
+	startMinutes, err := parseTimeOfDay(start)
+	if err != nil {
+		return err
+	}
+	endMinutes, err := parseTimeOfDay(end)
+	if err != nil {
+		return err
+	}
+	if endMinutes <= startMinutes {
+		return fmt.Errorf("availability must end after it starts")
+	}
+	return nil
+}
+
+// clockWindow converts a validated window to minutes since midnight.
+func clockWindow(start, end string) [2]int {
+	startMinutes, _ := parseTimeOfDay(start)
+	endMinutes, _ := parseTimeOfDay(end)
+	return [2]int{startMinutes, endMinutes}
+}
+
+// parseTimeOfDay parses an "HH:MM" time of day into minutes since midnight. "24:00" is the end of the day.
+func parseTimeOfDay(value string) (int, error) {
+	hourStr, minuteStr, ok := strings.Cut(value, ":")
+	hour, hourErr := strconv.Atoi(hourStr)
+	minute, minuteErr := strconv.Atoi(minuteStr)
+	if !ok || len(minuteStr) != 2 || hourErr != nil || minuteErr != nil ||
+		hour < 0 || minute < 0 || minute > 59 || hour*60+minute > minutesPerDay {
+		return 0, fmt.Errorf("invalid time of day")
+	}
+	return hour*60 + minute, nil
+}
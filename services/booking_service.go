@@ -0,0 +1,349 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreateBooking registers a participant for an appointment and saves the booking to the database.
+func CreateBooking(req models.BookingRequest) (*models.Booking, error) {
+	// Validate time range
+	if !req.EndTime.After(req.StartTime) {
+		return nil, fmt.Errorf("end time must be after start time")
+	}
+
+	var booking *models.Booking
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockAppointment(tx, req.AppointmentID.String())
+		if err != nil {
+			return err
+		}
+
+		booking, err = bookSlot(tx, appointment, req.UserID, req.StartTime, req.EndTime, &req.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return booking, nil
+}
+
+// lockAppointment loads an appointment and locks its row so concurrent bookings for it are serialized.
+// Pending bookings past their approval time are expired first, so their places are free again.
+func lockAppointment(tx *gorm.DB, appointmentID string) (*models.Appointment, error) {
+	var appointment models.Appointment
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&appointment, "id = ?", appointmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		return nil, fmt.Errorf("failed to load appointment: %w", err)
+	}
+	if err := expirePendingBookings(tx, &appointment, time.Now()); err != nil {
+		return nil, err
+	}
+	return &appointment, nil
+}
+
+// bookSlot creates a booking for the user on the slot matching the given range, pending when the
+// appointment requires approval. changedBy is recorded as the author of the booking, nil when
+// the system made it. The caller must hold the appointment lock.
+func bookSlot(tx *gorm.DB, appointment *models.Appointment, userID uuid.UUID, start, end time.Time, changedBy *uuid.UUID) (*models.Booking, error) {
+	// The booking must cover exactly one free slot of the appointment
+	slots, err := slotsWithStatus(tx, appointment)
+	if err != nil {
+		return nil, err
+	}
+	slot, ok := findSlot(slots, start, end)
+	if !ok {
+		return nil, fmt.Errorf("booking must match an appointment slot")
+	}
+	now := time.Now()
+	if err := checkBookingWindow(appointment, start, now); err != nil {
+		return nil, err
+	}
+	if err := checkAvailability(tx, appointment.UserID, start, end); err != nil {
+		return nil, err
+	}
+	if err := checkCapacity(tx, appointment, slot); err != nil {
+		return nil, err
+	}
+
+	// Check for overlapping bookings held by the participant
+	if err := checkParticipantOverlap(tx, userID, start, end, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	status, expiresAt := initialBookingStatus(appointment, start, now)
+	booking := &models.Booking{
+		UserID:        userID,
+		AppointmentID: appointment.ID,
+		StartTime:     start,
+		EndTime:       end,
+		Status:        status,
+		ExpiresAt:     expiresAt,
+	}
+	if err := tx.Create(booking).Error; err != nil {
+		return nil, fmt.Errorf("failed to create booking: %w", err)
+	}
+	if err := recordBookingTransition(tx, booking.ID, "", status, changedBy, ""); err != nil {
+		return nil, err
+	}
+	if err := recordEvent(tx, models.AggregateBooking, booking.ID, models.EventBookingCreated, changedBy, bookingEventData(booking)); err != nil {
+		return nil, err
+	}
+	if status == models.BookingConfirmed {
+		if err := scheduleBookingReminders(tx, booking); err != nil {
+			return nil, err
+		}
+	}
+	return booking, nil
+}
+
+// checkParticipantOverlap reports whether the user already holds a booking, other than
+// the excluded one, overlapping the range.
+func checkParticipantOverlap(tx *gorm.DB, userID uuid.UUID, start, end time.Time, excludeID uuid.UUID) error {
+	var count int64
+	if err := tx.Model(&models.Booking{}).
+		Where("user_id = ? AND id <> ? AND start_time < ? AND end_time > ?", userID, excludeID, end, start).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check for overlapping bookings: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("overlapping booking exists")
+	}
+	return nil
+}
+
+// checkCapacity reports whether the appointment or the given slot is full. It must run
+// inside the transaction holding the appointment lock so the counts cannot change under it.
+func checkCapacity(tx *gorm.DB, appointment *models.Appointment, slot models.Slot) error {
+	if appointment.Capacity > 0 {
+		var total int64
+		if err := tx.Model(&models.Booking{}).
+			Where("appointment_id = ?", appointment.ID).
+			Count(&total).Error; err != nil {
+			return fmt.Errorf("failed to count bookings: %w", err)
+		}
+		if int(total) >= appointment.Capacity {
+			return fmt.Errorf("appointment is full")
+		}
+	}
+	if slot.Booked >= appointment.SlotCapacity {
+		return fmt.Errorf("slot is full")
+	}
+	return nil
+}
+
+// JoinAppointment books the user onto the appointment identified by its share code.
+// When no time range is given the first free slot is booked.
+func JoinAppointment(code string, req models.BookingRequest) (*models.Booking, error) {
+	appointment, err := GetAppointmentByCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	req.AppointmentID = appointment.ID
+	if req.StartTime.IsZero() && req.EndTime.IsZero() {
+		slots, err := slotsWithStatus(db.DB, appointment)
+		if err != nil {
+			return nil, err
+		}
+		for _, slot := range slots {
+			if slot.Status == models.SlotFree {
+				req.StartTime = slot.StartTime
+				req.EndTime = slot.EndTime
+				break
+			}
+		}
+		if req.StartTime.IsZero() {
+			return nil, fmt.Errorf("no free slots available")
+		}
+	}
+
+	return CreateBooking(req)
+}
+
+// GetBookingsForAppointment retrieves the bookings for an appointment. The organizer
+// sees every booking, other users only see their own.
+func GetBookingsForAppointment(appointmentID string, userID uuid.UUID) ([]models.Booking, error) {
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, "id = ?", appointmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		return nil, err
+	}
+
+	query := db.DB.Preload("History", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Preload("Transitions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at")
+	}).Where("appointment_id = ?", appointment.ID)
+	if appointment.UserID != userID {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var bookings []models.Booking
+	if err := query.Order("start_time").Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+	return bookings, nil
+}
+
+// CancelBooking soft deletes a booking and promotes waiting users into the freed place.
+// Only the participant or the appointment organizer may cancel it.
+func CancelBooking(appointmentID, bookingID string, userID uuid.UUID, reason string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockAppointment(tx, appointmentID)
+		if err != nil {
+			return err
+		}
+
+		var booking models.Booking
+		if err := tx.Where("id = ? AND appointment_id = ?", bookingID, appointment.ID).
+			First(&booking).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("booking not found")
+			}
+			return fmt.Errorf("failed to load booking: %w", err)
+		}
+
+		if booking.UserID != userID && appointment.UserID != userID {
+			return fmt.Errorf("not allowed to cancel this booking")
+		}
+
+		if reason == "" {
+			reason = "cancelled by participant"
+			if booking.UserID != userID {
+				reason = "cancelled by organizer"
+			}
+		}
+		if err := transitionBooking(tx, &booking, models.BookingCancelled, &userID, reason); err != nil {
+			return err
+		}
+
+		return promoteWaitlist(tx, appointment)
+	})
+}
+
+// RescheduleBooking moves a booking to another slot of its appointment in a single transaction.
+// The previous times are kept in the booking history and the released place goes to the waitlist.
+// Nothing changes if the new slot is not available.
+func RescheduleBooking(appointmentID, bookingID string, userID uuid.UUID, req models.RescheduleRequest) (*models.Booking, error) {
+	var booking models.Booking
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockAppointment(tx, appointmentID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Where("id = ? AND appointment_id = ?", bookingID, appointment.ID).
+			First(&booking).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("booking not found")
+			}
+			return fmt.Errorf("failed to load booking: %w", err)
+		}
+
+		if booking.UserID != userID && appointment.UserID != userID {
+			return fmt.Errorf("not allowed to reschedule this booking")
+		}
+		if !isActiveBooking(&booking) {
+			return fmt.Errorf("booking is no longer active")
+		}
+		if booking.StartTime.Equal(req.StartTime) && booking.EndTime.Equal(req.EndTime) {
+			return fmt.Errorf("booking is already in this slot")
+		}
+
+		// The new slot must exist and have room; the booking already counts toward the total capacity
+		slots, err := slotsWithStatus(tx, appointment)
+		if err != nil {
+			return err
+		}
+		slot, ok := findSlot(slots, req.StartTime, req.EndTime)
+		if !ok {
+			return fmt.Errorf("booking must match an appointment slot")
+		}
+		if err := checkBookingWindow(appointment, req.StartTime, time.Now()); err != nil {
+			return err
+		}
+		if slot.Booked >= appointment.SlotCapacity {
+			return fmt.Errorf("slot is full")
+		}
+		if err := checkAvailability(tx, appointment.UserID, req.StartTime, req.EndTime); err != nil {
+			return err
+		}
+		if err := checkParticipantOverlap(tx, booking.UserID, req.StartTime, req.EndTime, booking.ID); err != nil {
+			return err
+		}
+
+		history := &models.BookingHistory{
+			BookingID: booking.ID,
+			StartTime: booking.StartTime,
+			EndTime:   booking.EndTime,
+			ChangedBy: userID,
+		}
+		if err := tx.Create(history).Error; err != nil {
+			return fmt.Errorf("failed to record booking history: %w", err)
+		}
+
+		booking.StartTime = req.StartTime
+		booking.EndTime = req.EndTime
+		if err := tx.Save(&booking).Error; err != nil {
+			return fmt.Errorf("failed to reschedule booking: %w", err)
+		}
+
+		// Reminders follow the booking to its new time
+		if err := cancelBookingReminders(tx, booking.ID); err != nil {
+			return err
+		}
+		if booking.Status == models.BookingConfirmed {
+			if err := scheduleBookingReminders(tx, &booking); err != nil {
+				return err
+			}
+		}
+		event := bookingEventData(&booking)
+		event.PreviousStartTime = &history.StartTime
+		event.PreviousEndTime = &history.EndTime
+		if err := recordEvent(tx, models.AggregateBooking, booking.ID, models.EventBookingUpdated, &userID, event); err != nil {
+			return err
+		}
+
+		if err := promoteWaitlist(tx, appointment); err != nil {
+			return err
+		}
+
+		return tx.Where("booking_id = ?", booking.ID).Order("created_at").Find(&booking.History).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &booking, nil
+}
+
+// cancelBookings cancels the pending and confirmed bookings matched by the query with the
+// given reason. Bookings that already took place are kept.
+func cancelBookings(tx *gorm.DB, query *gorm.DB, reason string, changedBy *uuid.UUID) error {
+	var bookings []models.Booking
+	if err := query.Where("status IN ?", []string{models.BookingPending, models.BookingConfirmed}).
+		Find(&bookings).Error; err != nil {
+		return fmt.Errorf("failed to load bookings: %w", err)
+	}
+
+	for i := range bookings {
+		if err := transitionBooking(tx, &bookings[i], models.BookingCancelled, changedBy, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
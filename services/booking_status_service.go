@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+)
+
+// defaultApprovalTimeout is how long a booking may stay pending when the appointment does not say
+const defaultApprovalTimeout = 24 * time.Hour
+
+// bookingTransitions lists the statuses each booking status may move to. The empty status
+// is the initial one of a new booking.
+var bookingTransitions = map[string][]string{
+	"":                      {models.BookingPending, models.BookingConfirmed},
+	models.BookingPending:   {models.BookingConfirmed, models.BookingDeclined, models.BookingCancelled},
+	models.BookingConfirmed: {models.BookingCancelled, models.BookingNoShow, models.BookingCompleted},
+}
+
+// canTransition reports whether a booking may move from one status to another.
+func canTransition(from, to string) bool {
+	for _, allowed := range bookingTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isActiveBooking reports whether the booking still waits for, or is set to take, its slot.
+func isActiveBooking(booking *models.Booking) bool {
+	return booking.Status == models.BookingPending || booking.Status == models.BookingConfirmed
+}
+
+// ChangeBookingStatus moves a booking to the requested status on behalf of the appointment
+// organizer: approving or declining a pending booking, cancelling it, or recording the
+// outcome of a confirmed one once it has started.
+func ChangeBookingStatus(appointmentID, bookingID string, userID uuid.UUID, req models.BookingStatusRequest) (*models.Booking, error) {
+	switch req.Status {
+	case models.BookingConfirmed, models.BookingDeclined, models.BookingCancelled, models.BookingNoShow, models.BookingCompleted:
+	default:
+		return nil, fmt.Errorf("invalid booking status")
+	}
+
+	var booking models.Booking
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockAppointment(tx, appointmentID)
+		if err != nil {
+			return err
+		}
+		if appointment.UserID != userID {
+			return fmt.Errorf("not allowed to change this booking")
+		}
+
+		if err := tx.Where("id = ? AND appointment_id = ?", bookingID, appointment.ID).
+			First(&booking).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("booking not found")
+			}
+			return fmt.Errorf("failed to load booking: %w", err)
+		}
+
+		if (req.Status == models.BookingNoShow || req.Status == models.BookingCompleted) && time.Now().Before(booking.StartTime) {
+			return fmt.Errorf("booking has not started yet")
+		}
+		if err := transitionBooking(tx, &booking, req.Status, &userID, req.Reason); err != nil {
+			return err
+		}
+
+		// Declined and cancelled bookings free their place
+		if req.Status == models.BookingDeclined || req.Status == models.BookingCancelled {
+			if err := promoteWaitlist(tx, appointment); err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("booking_id = ?", booking.ID).Order("created_at").Find(&booking.Transitions).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &booking, nil
+}
+
+// transitionBooking moves the booking to a new status and records who changed it. Declined
+// and cancelled bookings keep the reason and are soft deleted, which frees their place.
+func transitionBooking(tx *gorm.DB, booking *models.Booking, to string, changedBy *uuid.UUID, reason string) error {
+	if !canTransition(booking.Status, to) {
+		return fmt.Errorf("invalid status transition")
+	}
+
+	if err := recordBookingTransition(tx, booking.ID, booking.Status, to, changedBy, reason); err != nil {
+		return err
+	}
+
+	updates := map[string]interface{}{"status": to, "expires_at": nil}
+	if to == models.BookingDeclined || to == models.BookingCancelled {
+		updates["cancel_reason"] = reason
+	}
+	if err := tx.Model(booking).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update booking status: %w", err)
+	}
+	from := booking.Status
+	booking.Status = to
+	booking.ExpiresAt = nil
+
+	// Only confirmed bookings are reminded of
+	if to == models.BookingConfirmed {
+		if err := scheduleBookingReminders(tx, booking); err != nil {
+			return err
+		}
+	} else if err := cancelBookingReminders(tx, booking.ID); err != nil {
+		return err
+	}
+
+	event := models.EventBookingUpdated
+	if to == models.BookingDeclined || to == models.BookingCancelled {
+		booking.CancelReason = reason
+		if err := tx.Delete(booking).Error; err != nil {
+			return fmt.Errorf("failed to release booking: %w", err)
+		}
+		event = models.EventBookingCancelled
+	}
+	data := bookingEventData(booking)
+	data.PreviousStatus = from
+	data.Reason = reason
+	return recordEvent(tx, models.AggregateBooking, booking.ID, event, changedBy, data)
+}
+
+// recordBookingTransition adds a status change to the audit trail of a booking.
+func recordBookingTransition(tx *gorm.DB, bookingID uuid.UUID, from, to string, changedBy *uuid.UUID, reason string) error {
+	transition := &models.BookingTransition{
+		BookingID:  bookingID,
+		FromStatus: from,
+		ToStatus:   to,
+		ChangedBy:  changedBy,
+		Reason:     reason,
+	}
+	if err := tx.Create(transition).Error; err != nil {
+		return fmt.Errorf("failed to record booking transition: %w", err)
+	}
+	return nil
+}
+
+// initialBookingStatus returns the status of a new booking and, for pending ones, when they
+// expire. A pending booking expires at its start time at the latest.
+func initialBookingStatus(appointment *models.Appointment, start, now time.Time) (string, *time.Time) {
+	if !appointment.RequiresApproval {
+		return models.BookingConfirmed, nil
+	}
+
+	timeout := appointment.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	expiresAt := now.Add(timeout)
+	if start.After(now) && start.Before(expiresAt) {
+		expiresAt = start
+	}
+	return models.BookingPending, &expiresAt
+}
+
+// expirePendingBookings declines the pending bookings of a locked appointment that were not
+// approved in time and hands their places to the waitlist.
+func expirePendingBookings(tx *gorm.DB, appointment *models.Appointment, now time.Time) error {
+	var expired []models.Booking
+	if err := tx.Where("appointment_id = ? AND status = ? AND expires_at <= ?", appointment.ID, models.BookingPending, now).
+		Find(&expired).Error; err != nil {
+		return fmt.Errorf("failed to load pending bookings: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	for i := range expired {
+		if err := transitionBooking(tx, &expired[i], models.BookingDeclined, nil, "approval expired"); err != nil {
+			return err
+		}
+		log.Printf("Expired pending booking %s", expired[i].ID)
+	}
+	return promoteWaitlist(tx, appointment)
+}
+
+// ExpirePendingBookings declines every pending booking whose approval time has passed and
+// returns how many appointments were affected.
+func ExpirePendingBookings() (int, error) {
+	var appointmentIDs []uuid.UUID
+	if err := db.DB.Model(&models.Booking{}).Distinct("appointment_id").
+		Where("status = ? AND expires_at <= ?", models.BookingPending, time.Now()).
+		Pluck("appointment_id", &appointmentIDs).Error; err != nil {
+		return 0, fmt.Errorf("failed to find expired bookings: %w", err)
+	}
+
+	// Locking the appointment expires its bookings
+	for _, appointmentID := range appointmentIDs {
+		if err := db.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := lockAppointment(tx, appointmentID.String())
+			return err
+		}); err != nil && err.Error() != "appointment not found" {
+			return 0, err
+		}
+	}
+	return len(appointmentIDs), nil
+}
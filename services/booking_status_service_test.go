@@ -0,0 +1,165 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	models "github.com/m13ha/appointment_master/models"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{
+		"",
+		models.BookingPending,
+		models.BookingConfirmed,
+		models.BookingDeclined,
+		models.BookingCancelled,
+		models.BookingNoShow,
+		models.BookingCompleted,
+	}
+	allowed := map[[2]string]bool{
+		{"", models.BookingPending}:                        true,
+		{"", models.BookingConfirmed}:                      true,
+		{models.BookingPending, models.BookingConfirmed}:   true,
+		{models.BookingPending, models.BookingDeclined}:    true,
+		{models.BookingPending, models.BookingCancelled}:   true,
+		{models.BookingConfirmed, models.BookingCancelled}: true,
+		{models.BookingConfirmed, models.BookingNoShow}:    true,
+		{models.BookingConfirmed, models.BookingCompleted}: true,
+	}
+
+	// Every pair not listed, including staying in the same status, is rejected
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%q, %q) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+	if canTransition(models.BookingPending, "unknown") || canTransition("unknown", models.BookingConfirmed) {
+		t.Error("transition with an unknown status was allowed")
+	}
+}
+
+func TestBookingTransitionsTargetKnownStatuses(t *testing.T) {
+	known := map[string]bool{
+		models.BookingPending:   true,
+		models.BookingConfirmed: true,
+		models.BookingDeclined:  true,
+		models.BookingCancelled: true,
+		models.BookingNoShow:    true,
+		models.BookingCompleted: true,
+	}
+	for from, targets := range bookingTransitions {
+		if from != "" && !known[from] {
+			t.Errorf("transitions listed from unknown status %q", from)
+		}
+		for _, to := range targets {
+			if !known[to] {
+				t.Errorf("transition from %q to unknown status %q", from, to)
+			}
+		}
+	}
+}
+
+func TestTransitionBookingRejectsInvalidTransitions(t *testing.T) {
+	changedBy := uuid.New()
+	tests := []struct {
+		from string
+		to   string
+	}{
+		{"", models.BookingCancelled},
+		{models.BookingPending, models.BookingPending},
+		{models.BookingPending, models.BookingCompleted},
+		{models.BookingConfirmed, models.BookingDeclined},
+		{models.BookingConfirmed, models.BookingPending},
+		{models.BookingDeclined, models.BookingConfirmed},
+		{models.BookingCancelled, models.BookingConfirmed},
+		{models.BookingNoShow, models.BookingCompleted},
+		{models.BookingCompleted, models.BookingCancelled},
+	}
+	for _, tt := range tests {
+		booking := &models.Booking{Status: tt.from}
+		// The transition is refused before the database is touched
+		err := transitionBooking(nil, booking, tt.to, &changedBy, "reason")
+		if err == nil || err.Error() != "invalid status transition" {
+			t.Errorf("transitionBooking from %q to %q error = %v, want invalid status transition", tt.from, tt.to, err)
+		}
+		if booking.Status != tt.from || booking.CancelReason != "" {
+			t.Errorf("rejected transition from %q to %q changed the booking to %+v", tt.from, tt.to, booking)
+		}
+	}
+}
+
+func TestChangeBookingStatusRejectsUnknownStatuses(t *testing.T) {
+	for _, status := range []string{"", models.BookingPending, "approved"} {
+		_, err := ChangeBookingStatus(uuid.NewString(), uuid.NewString(), uuid.New(), models.BookingStatusRequest{Status: status})
+		if err == nil || err.Error() != "invalid booking status" {
+			t.Errorf("ChangeBookingStatus to %q error = %v, want invalid booking status", status, err)
+		}
+	}
+}
+
+func TestInitialBookingStatus(t *testing.T) {
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	ptr := func(t time.Time) *time.Time { return &t }
+
+	tests := []struct {
+		name        string
+		appointment models.Appointment
+		start       time.Time
+		status      string
+		expiresAt   *time.Time
+	}{
+		{
+			name:        "no approval needed",
+			appointment: models.Appointment{},
+			start:       now.Add(72 * time.Hour),
+			status:      models.BookingConfirmed,
+		},
+		{
+			name:        "default approval timeout",
+			appointment: models.Appointment{RequiresApproval: true},
+			start:       now.Add(72 * time.Hour),
+			status:      models.BookingPending,
+			expiresAt:   ptr(now.Add(defaultApprovalTimeout)),
+		},
+		{
+			name:        "custom approval timeout",
+			appointment: models.Appointment{RequiresApproval: true, ApprovalTimeout: 2 * time.Hour},
+			start:       now.Add(72 * time.Hour),
+			status:      models.BookingPending,
+			expiresAt:   ptr(now.Add(2 * time.Hour)),
+		},
+		{
+			name:        "expires at the start at the latest",
+			appointment: models.Appointment{RequiresApproval: true},
+			start:       now.Add(3 * time.Hour),
+			status:      models.BookingPending,
+			expiresAt:   ptr(now.Add(3 * time.Hour)),
+		},
+		{
+			name:        "already started",
+			appointment: models.Appointment{RequiresApproval: true, ApprovalTimeout: time.Hour},
+			start:       now.Add(-time.Hour),
+			status:      models.BookingPending,
+			expiresAt:   ptr(now.Add(time.Hour)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, expiresAt := initialBookingStatus(&tt.appointment, tt.start, now)
+			if status != tt.status {
+				t.Errorf("status = %q, want %q", status, tt.status)
+			}
+			switch {
+			case tt.expiresAt == nil && expiresAt != nil:
+				t.Errorf("expires at %v, want no expiry", *expiresAt)
+			case tt.expiresAt != nil && (expiresAt == nil || !expiresAt.Equal(*tt.expiresAt)):
+				t.Errorf("expires at %v, want %v", expiresAt, *tt.expiresAt)
+			}
+		})
+	}
+}
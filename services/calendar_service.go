@@ -0,0 +1,240 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+// icalUIDDomain makes calendar UIDs globally unique
+const icalUIDDomain = "appointment_master"
+
+// cancelledFeedRetention is how long cancelled appointments and bookings stay in calendars as
+// cancelled events, so subscribed clients learn about the cancellation
+const cancelledFeedRetention = 30 * 24 * time.Hour
+
+// GetAppointmentCalendar renders an appointment as an iCalendar document. Only the organizer
+// and participants of the appointment may export it.
+func GetAppointmentCalendar(appointmentID string, userID uuid.UUID) (string, error) {
+	var appointment models.Appointment
+	if err := db.DB.Preload("User").First(&appointment, "id = ?", appointmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("appointment not found")
+		}
+		return "", err
+	}
+
+	var bookings []models.Booking
+	if err := db.DB.Preload("User").
+		Where("appointment_id = ?", appointment.ID).
+		Order("start_time").Find(&bookings).Error; err != nil {
+		return "", err
+	}
+
+	if appointment.UserID != userID && !hasBooking(bookings, userID) {
+		return "", fmt.Errorf("not allowed to view this appointment")
+	}
+
+	event := appointmentEvent(&appointment, bookings)
+	return utils.BuildICalendar(appointment.Title, []utils.ICalEvent{event}), nil
+}
+
+// GetUserCalendar renders the appointments created by the user and the bookings they hold
+// as a single iCalendar document.
+func GetUserCalendar(userID uuid.UUID) (string, error) {
+	events, err := userCalendarEvents(userID)
+	if err != nil {
+		return "", err
+	}
+	return utils.BuildICalendar("My appointments", events), nil
+}
+
+// userCalendarEvents builds one event per created appointment and one per booking of the user.
+// Appointments and bookings cancelled within cancelledFeedRetention are included as cancelled.
+func userCalendarEvents(userID uuid.UUID) ([]utils.ICalEvent, error) {
+	cutoff := time.Now().Add(-cancelledFeedRetention)
+
+	var appointments []models.Appointment
+	if err := db.DB.Unscoped().Preload("User").
+		Where("user_id = ? AND (deleted_at IS NULL OR deleted_at > ?)", userID, cutoff).
+		Order("start_time").Find(&appointments).Error; err != nil {
+		return nil, err
+	}
+
+	// Load the attendees of every appointment at once
+	appointmentIDs := make([]uuid.UUID, 0, len(appointments))
+	for _, appointment := range appointments {
+		appointmentIDs = append(appointmentIDs, appointment.ID)
+	}
+	attendees := make(map[uuid.UUID][]models.Booking, len(appointments))
+	if len(appointmentIDs) > 0 {
+		var bookings []models.Booking
+		if err := db.DB.Preload("User").
+			Where("appointment_id IN ?", appointmentIDs).
+			Order("start_time").Find(&bookings).Error; err != nil {
+			return nil, err
+		}
+		for _, booking := range bookings {
+			attendees[booking.AppointmentID] = append(attendees[booking.AppointmentID], booking)
+		}
+	}
+
+	var events []utils.ICalEvent
+	for i := range appointments {
+		events = append(events, appointmentEvent(&appointments[i], attendees[appointments[i].ID]))
+	}
+
+	var bookings []models.Booking
+	if err := db.DB.Unscoped().Preload("User").Preload("History").
+		Preload("Appointment", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Appointment.User").
+		Joins("JOIN appointments ON appointments.id = bookings.appointment_id").
+		Where("bookings.user_id = ?", userID).
+		Where("(bookings.deleted_at IS NULL OR bookings.deleted_at > ?) AND (appointments.deleted_at IS NULL OR appointments.deleted_at > ?)", cutoff, cutoff).
+		Order("bookings.start_time").Find(&bookings).Error; err != nil {
+		return nil, err
+	}
+	for i := range bookings {
+		events = append(events, bookingEvent(&bookings[i]))
+	}
+
+	return events, nil
+}
+
+// appointmentEvent converts an appointment and its bookings into a calendar event. A cancelled
+// appointment is exported as cancelled with a raised sequence, so clients update their copy.
+func appointmentEvent(appointment *models.Appointment, bookings []models.Booking) utils.ICalEvent {
+	event := utils.ICalEvent{
+		UID:         fmt.Sprintf("%s@%s", appointment.ID, icalUIDDomain),
+		Summary:     appointment.Title,
+		Description: "Share code: " + appointment.AppCode,
+		Start:       appointment.StartTime,
+		End:         appointment.EndTime,
+		Stamp:       appointment.UpdatedAt,
+		Sequence:    appointment.Sequence,
+		Status:      "CONFIRMED",
+		Organizer:   &utils.ICalPerson{Name: appointment.User.Name, Email: appointment.User.Email},
+	}
+	if appointment.DeletedAt.Valid {
+		event.Status = "CANCELLED"
+		event.Sequence++
+		event.Stamp = appointment.DeletedAt.Time
+	}
+
+	seen := make(map[uuid.UUID]bool, len(bookings))
+	for _, booking := range bookings {
+		if seen[booking.UserID] {
+			continue
+		}
+		seen[booking.UserID] = true
+		event.Attendees = append(event.Attendees, utils.ICalPerson{Name: booking.User.Name, Email: booking.User.Email})
+	}
+	return event
+}
+
+// bookingEvent converts a booking into a calendar event for its participant. Every reschedule
+// of the booking and every change to its appointment raises the sequence, and so does the
+// cancellation of either, which marks the event cancelled.
+func bookingEvent(booking *models.Booking) utils.ICalEvent {
+	appointment := booking.Appointment
+	stamp := booking.UpdatedAt
+	if appointment.UpdatedAt.After(stamp) {
+		stamp = appointment.UpdatedAt
+	}
+	sequence := appointment.Sequence + len(booking.History)
+	status := "CONFIRMED"
+	if booking.Status == models.BookingPending {
+		status = "TENTATIVE"
+	}
+	if booking.DeletedAt.Valid || appointment.DeletedAt.Valid {
+		status = "CANCELLED"
+		sequence++
+		for _, deletedAt := range []gorm.DeletedAt{booking.DeletedAt, appointment.DeletedAt} {
+			if deletedAt.Valid && deletedAt.Time.After(stamp) {
+				stamp = deletedAt.Time
+			}
+		}
+	}
+
+	return utils.ICalEvent{
+		UID:       fmt.Sprintf("booking-%s@%s", booking.ID, icalUIDDomain),
+		Summary:   appointment.Title,
+		Start:     booking.StartTime,
+		End:       booking.EndTime,
+		Stamp:     stamp,
+		Sequence:  sequence,
+		Status:    status,
+		Organizer: &utils.ICalPerson{Name: appointment.User.Name, Email: appointment.User.Email},
+		Attendees: []utils.ICalPerson{{Name: booking.User.Name, Email: booking.User.Email}},
+	}
+}
+
+// hasBooking reports whether the user holds one of the bookings.
+func hasBooking(bookings []models.Booking, userID uuid.UUID) bool {
+	for _, booking := range bookings {
+		if booking.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// RegenerateCalendarFeedToken issues a new calendar feed token for the user, invalidating
+// the previous one. Only its hash is stored, so the token is returned once.
+func RegenerateCalendarFeedToken(userID uuid.UUID) (string, error) {
+	token, err := utils.GenerateSecretToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate feed token: %w", err)
+	}
+
+	if err := setFeedTokenHash(userID, utils.HashSecretToken(token)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeCalendarFeedToken disables the user's calendar feed.
+func RevokeCalendarFeedToken(userID uuid.UUID) error {
+	return setFeedTokenHash(userID, "")
+}
+
+// setFeedTokenHash stores the hash of the user's calendar feed token, empty to disable the feed.
+func setFeedTokenHash(userID uuid.UUID, hash string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("user not found")
+			}
+			return err
+		}
+
+		if err := tx.Model(&user).Update("feed_token_hash", hash).Error; err != nil {
+			return fmt.Errorf("failed to store feed token: %w", err)
+		}
+		return recordUserEvent(tx, &user, models.EventUserUpdated, "calendar_feed")
+	})
+}
+
+// GetCalendarFeed renders the calendar of the user owning the feed token.
+func GetCalendarFeed(token string) (string, error) {
+	if token == "" {
+		return "", fmt.Errorf("feed not found")
+	}
+
+	var user models.User
+	if err := db.DB.Where("feed_token_hash = ?", utils.HashSecretToken(token)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("feed not found")
+		}
+		return "", err
+	}
+
+	return GetUserCalendar(user.ID)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+)
+
+const (
+	// maxFreeTimeUsers bounds how many calendars a single search compares
+	maxFreeTimeUsers = 50
+	// maxBufferSearch widens the appointment search so buffers reaching into the range are seen
+	maxBufferSearch = 24 * time.Hour
+)
+
+// FindCommonFreeTime returns the intervals within [from, to) lasting at least duration in which
+// every user is within their working hours and has no appointment or booking.
+func FindCommonFreeTime(userIDs []uuid.UUID, from, to time.Time, duration time.Duration) ([]models.Interval, error) {
+	if len(userIDs) == 0 {
+		return nil, fmt.Errorf("at least one user is required")
+	}
+	if len(userIDs) > maxFreeTimeUsers {
+		return nil, fmt.Errorf("too many users")
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if !to.After(from) {
+		return nil, fmt.Errorf("range end must be after its start")
+	}
+	if to.Sub(from) > maxAvailabilityRange {
+		return nil, fmt.Errorf("range is too long")
+	}
+
+	unique := uniqueIDs(userIDs)
+	var count int64
+	if err := db.DB.Model(&models.User{}).Where("id IN ?", unique).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(unique) {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	// Working hours are read in each user's own zone
+	hours := make([]*availability, 0, len(unique))
+	for _, userID := range unique {
+		userHours, err := loadAvailability(db.DB, userID)
+		if err != nil {
+			return nil, err
+		}
+		hours = append(hours, userHours)
+	}
+
+	// Appointments keep their buffers free as well
+	var appointments []models.Appointment
+	if err := db.DB.Select("start_time, end_time, buffer_before, buffer_after").
+		Where("user_id IN ? AND start_time < ? AND end_time > ?", userIDs, to.Add(maxBufferSearch), from.Add(-maxBufferSearch)).
+		Find(&appointments).Error; err != nil {
+		return nil, fmt.Errorf("failed to load appointments: %w", err)
+	}
+	busy := make([]models.Interval, 0, len(appointments))
+	for _, appointment := range appointments {
+		busy = append(busy, models.Interval{
+			StartTime: appointment.StartTime.Add(-appointment.BufferBefore),
+			EndTime:   appointment.EndTime.Add(appointment.BufferAfter),
+		})
+	}
+	var booked []models.Interval
+	if err := db.DB.Model(&models.Booking{}).Select("bookings.start_time, bookings.end_time").
+		Joins("JOIN appointments ON appointments.id = bookings.appointment_id AND appointments.deleted_at IS NULL").
+		Where("bookings.user_id IN ? AND bookings.start_time < ? AND bookings.end_time > ?", userIDs, to, from).
+		Scan(&booked).Error; err != nil {
+		return nil, fmt.Errorf("failed to load bookings: %w", err)
+	}
+
+	return commonFreeTime(hours, append(busy, booked...), from, to, duration), nil
+}
+
+// commonFreeTime returns the intervals within [from, to) lasting at least duration that are
+// within the working hours of everyone and overlap none of the busy ranges.
+func commonFreeTime(hours []*availability, busy []models.Interval, from, to time.Time, duration time.Duration) []models.Interval {
+	free := []models.Interval{{StartTime: from.UTC(), EndTime: to.UTC()}}
+	for _, userHours := range hours {
+		free = intersectIntervals(free, userHours.intervals(from, to))
+	}
+	free = subtractIntervals(free, mergeIntervals(busy))
+
+	candidates := make([]models.Interval, 0, len(free))
+	for _, interval := range free {
+		if interval.EndTime.Sub(interval.StartTime) >= duration {
+			candidates = append(candidates, interval)
+		}
+	}
+	return candidates
+}
+
+// intersectIntervals returns the ranges covered by both sorted, merged interval lists.
+func intersectIntervals(a, b []models.Interval) []models.Interval {
+	var result []models.Interval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start := laterTime(a[i].StartTime, b[j].StartTime)
+		end := earlierTime(a[i].EndTime, b[j].EndTime)
+		if end.After(start) {
+			result = append(result, models.Interval{StartTime: start, EndTime: end})
+		}
+		if a[i].EndTime.Before(b[j].EndTime) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return result
+}
+
+// subtractIntervals removes the sorted, merged busy ranges from the sorted free ranges.
+func subtractIntervals(free, busy []models.Interval) []models.Interval {
+	var result []models.Interval
+	for _, interval := range free {
+		start := interval.StartTime
+		for _, taken := range busy {
+			if !taken.EndTime.After(start) || !taken.StartTime.Before(interval.EndTime) {
+				continue
+			}
+			if taken.StartTime.After(start) {
+				result = append(result, models.Interval{StartTime: start, EndTime: taken.StartTime})
+			}
+			start = laterTime(start, taken.EndTime)
+		}
+		if interval.EndTime.After(start) {
+			result = append(result, models.Interval{StartTime: start, EndTime: interval.EndTime})
+		}
+	}
+	return result
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence.
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+func laterTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func earlierTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
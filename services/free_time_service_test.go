@@ -0,0 +1,180 @@
+package services
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	models "github.com/m13ha/appointment_master/models"
+)
+
+// at returns the given time of day in March 2025, in UTC.
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, 3, day, hour, minute, 0, 0, time.UTC)
+}
+
+func interval(start, end time.Time) models.Interval {
+	return models.Interval{StartTime: start, EndTime: end}
+}
+
+// weekdayHours returns working hours from start to end on Monday to Friday.
+func weekdayHours(loc *time.Location, start, end string) *availability {
+	hours := &availability{loc: loc, overrides: make(map[string][]models.AvailabilityOverride)}
+	for weekday := 1; weekday <= 5; weekday++ {
+		hours.rules = append(hours.rules, models.AvailabilityRule{Weekday: weekday, StartTime: start, EndTime: end})
+	}
+	return hours
+}
+
+func TestCommonFreeTime(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	office := weekdayHours(time.UTC, "09:00", "17:00")
+	berlinOffice := weekdayHours(berlin, "09:00", "17:00")
+	anytime := &availability{loc: time.UTC}
+	weekends := &availability{loc: time.UTC, rules: []models.AvailabilityRule{
+		{Weekday: 0, StartTime: "00:00", EndTime: "24:00"},
+		{Weekday: 6, StartTime: "00:00", EndTime: "24:00"},
+	}}
+	offTuesday := weekdayHours(time.UTC, "09:00", "17:00")
+	offTuesday.overrides["2025-03-04"] = []models.AvailabilityOverride{{Unavailable: true}}
+	shortTuesday := weekdayHours(time.UTC, "09:00", "17:00")
+	shortTuesday.overrides["2025-03-04"] = []models.AvailabilityOverride{{StartTime: "13:00", EndTime: "15:00"}}
+
+	// Monday 3 March to Wednesday 5 March 2025
+	from, to := at(3, 0, 0), at(5, 0, 0)
+
+	tests := []struct {
+		name     string
+		hours    []*availability
+		busy     []models.Interval
+		duration time.Duration
+		want     []models.Interval
+	}{
+		{
+			name:     "open calendar",
+			hours:    []*availability{anytime},
+			duration: time.Hour,
+			want:     []models.Interval{interval(from, to)},
+		},
+		{
+			name:     "working hours",
+			hours:    []*availability{office},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 9, 0), at(3, 17, 0)), interval(at(4, 9, 0), at(4, 17, 0))},
+		},
+		{
+			name:     "working hours in different zones",
+			hours:    []*availability{office, berlinOffice},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 9, 0), at(3, 16, 0)), interval(at(4, 9, 0), at(4, 16, 0))},
+		},
+		{
+			name:     "no shared working hours",
+			hours:    []*availability{office, weekends},
+			duration: time.Minute,
+			want:     []models.Interval{},
+		},
+		{
+			name:     "day off",
+			hours:    []*availability{office, offTuesday},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 9, 0), at(3, 17, 0))},
+		},
+		{
+			name:     "shortened day",
+			hours:    []*availability{office, shortTuesday},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 9, 0), at(3, 17, 0)), interval(at(4, 13, 0), at(4, 15, 0))},
+		},
+		{
+			name:  "overlapping busy ranges out of order",
+			hours: []*availability{office, berlinOffice},
+			busy: []models.Interval{
+				interval(at(3, 10, 30), at(3, 12, 0)),
+				interval(at(3, 10, 0), at(3, 11, 0)),
+			},
+			duration: time.Hour,
+			want: []models.Interval{
+				interval(at(3, 9, 0), at(3, 10, 0)),
+				interval(at(3, 12, 0), at(3, 16, 0)),
+				interval(at(4, 9, 0), at(4, 16, 0)),
+			},
+		},
+		{
+			name:  "busy ranges touching working hours",
+			hours: []*availability{office},
+			busy: []models.Interval{
+				interval(at(3, 8, 0), at(3, 9, 0)),
+				interval(at(3, 17, 0), at(3, 18, 0)),
+			},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 9, 0), at(3, 17, 0)), interval(at(4, 9, 0), at(4, 17, 0))},
+		},
+		{
+			name:  "gaps shorter than the duration",
+			hours: []*availability{office},
+			busy: []models.Interval{
+				interval(at(3, 9, 30), at(3, 16, 30)),
+				interval(at(4, 10, 0), at(4, 11, 0)),
+			},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(4, 9, 0), at(4, 10, 0)), interval(at(4, 11, 0), at(4, 17, 0))},
+		},
+		{
+			name:     "busy outside the range",
+			hours:    []*availability{anytime},
+			busy:     []models.Interval{interval(at(2, 20, 0), at(3, 1, 0)), interval(at(4, 23, 0), at(5, 2, 0))},
+			duration: time.Hour,
+			want:     []models.Interval{interval(at(3, 1, 0), at(4, 23, 0))},
+		},
+		{
+			name:     "fully booked",
+			hours:    []*availability{office},
+			busy:     []models.Interval{interval(from, to)},
+			duration: time.Minute,
+			want:     []models.Interval{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := commonFreeTime(tt.hours, tt.busy, from, to, tt.duration)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commonFreeTime = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindCommonFreeTimeValidation(t *testing.T) {
+	user := []uuid.UUID{uuid.New()}
+	tooMany := make([]uuid.UUID, maxFreeTimeUsers+1)
+	from := at(3, 0, 0)
+
+	tests := []struct {
+		name     string
+		userIDs  []uuid.UUID
+		to       time.Time
+		duration time.Duration
+		want     string
+	}{
+		{"no users", nil, at(4, 0, 0), time.Hour, "at least one user is required"},
+		{"too many users", tooMany, at(4, 0, 0), time.Hour, "too many users"},
+		{"zero duration", user, at(4, 0, 0), 0, "duration must be positive"},
+		{"empty range", user, from, time.Hour, "range end must be after its start"},
+		{"range too long", user, from.Add(maxAvailabilityRange + time.Hour), time.Hour, "range is too long"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FindCommonFreeTime(tt.userIDs, from, tt.to, tt.duration)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("FindCommonFreeTime error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+// errImportDryRun rolls back a dry-run import once every event has been tried
+var errImportDryRun = errors.New("import dry run")
+
+// ImportCalendar creates appointments for the user from the events of an iCalendar document.
+// Each event is created, or rejected, on its own with the same overlap check as CreateAppointment,
+// so one conflicting or malformed event does not prevent the others. A dry run reports the same results
+// without saving anything.
+func ImportCalendar(userID uuid.UUID, data string, dryRun bool) (*models.ImportResponse, error) {
+	events, err := utils.ParseICalendar(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar: %v", err)
+	}
+
+	response := &models.ImportResponse{DryRun: dryRun, Results: make([]models.ImportResult, 0, len(events))}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		for _, event := range events {
+			result, err := importEvent(tx, userID, event, dryRun)
+			if err != nil {
+				return err
+			}
+
+			switch result.Status {
+			case models.ImportCreated, models.ImportWouldCreate:
+				response.Created++
+			case models.ImportConflict:
+				response.Conflicts++
+			case models.ImportInvalid:
+				response.Invalid++
+			case models.ImportSkipped:
+				response.Skipped++
+			}
+			response.Results = append(response.Results, result)
+		}
+
+		if dryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// importEvent creates the appointments of one event inside a savepoint, so a rejected event
+// leaves nothing behind. Only unexpected failures are returned as errors.
+func importEvent(tx *gorm.DB, userID uuid.UUID, event utils.ParsedICalEvent, dryRun bool) (models.ImportResult, error) {
+	result := models.ImportResult{UID: event.UID, Title: event.Summary, StartTime: event.Start}
+	if event.Err != nil {
+		result.Status = models.ImportInvalid
+		result.Error = event.Err.Error()
+		return result, nil
+	}
+	if event.Status == "CANCELLED" {
+		result.Status = models.ImportSkipped
+		result.Error = "event is cancelled"
+		return result, nil
+	}
+
+	req := models.AppointmentRequest{
+		Title:     strings.TrimSpace(event.Summary),
+		StartTime: event.Start,
+		EndTime:   event.End,
+		RRule:     event.RRule,
+		ExDates:   event.ExDates,
+		UserID:    userID,
+	}
+	if req.Title == "" {
+		req.Title = "Imported event"
+	}
+
+	var created []models.Appointment
+	err := tx.Transaction(func(tx *gorm.DB) error {
+		if req.RRule != "" {
+			_, appointments, err := createRecurringAppointment(tx, req)
+			created = appointments
+			return err
+		}
+		appointment, err := createAppointment(tx, req)
+		if err == nil {
+			created = []models.Appointment{*appointment}
+		}
+		return err
+	})
+
+	switch {
+	case err == nil:
+		result.Status = models.ImportCreated
+		if dryRun {
+			result.Status = models.ImportWouldCreate
+		}
+		result.Occurrences = len(created)
+		if !dryRun {
+			for _, appointment := range created {
+				result.AppointmentIDs = append(result.AppointmentIDs, appointment.ID)
+			}
+		}
+	case err.Error() == "overlapping appointment exists", err.Error() == "outside organizer availability":
+		result.Status = models.ImportConflict
+		result.Error = err.Error()
+	case isAppointmentValidationError(err):
+		result.Status = models.ImportInvalid
+		result.Error = err.Error()
+	default:
+		return result, err
+	}
+	return result, nil
+}
+
+// isAppointmentValidationError reports whether the error rejects the appointment request itself.
+func isAppointmentValidationError(err error) bool {
+	if strings.HasPrefix(err.Error(), "invalid recurrence rule") {
+		return true
+	}
+	switch err.Error() {
+	case "title is required", "end time cannot be before start time", "duration cannot be negative",
+		"duration must be at least 1 minute", "appointment has too many slots",
+		"slot capacity must be at least 1", "capacity cannot be negative", "recurrence produces no occurrences",
+		"invalid time zone", "buffer cannot be negative", "booking window cannot be negative",
+		"maximum advance must be greater than minimum notice", "approval timeout cannot be negative":
+		return true
+	}
+	return false
+}
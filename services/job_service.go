@@ -0,0 +1,337 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Job kinds run by the scheduler.
+const (
+	JobSendReminder  = "send_reminder"
+	JobExpirePending = "expire_pending_bookings"
+	JobPurgeDeleted  = "purge_deleted"
+	JobPruneRevoked  = "prune_token_revocations"
+)
+
+const (
+	// jobBatchSize is how many due jobs a worker claims at once
+	jobBatchSize = 10
+	// jobLease is how long a claimed job may run before another worker may take it over
+	jobLease = 10 * time.Minute
+	// jobBaseBackoff and jobMaxBackoff bound the delay before a failed job is retried
+	jobBaseBackoff = 30 * time.Second
+	jobMaxBackoff  = time.Hour
+	// expireInterval, purgeInterval and pruneInterval are the periods of the maintenance jobs
+	expireInterval = time.Minute
+	purgeInterval  = 24 * time.Hour
+	pruneInterval  = time.Hour
+	// defaultPurgeRetention is how long soft-deleted rows are kept
+	defaultPurgeRetention = 30 * 24 * time.Hour
+	// defaultMaxAttempts is how often a job runs before it is marked failed
+	defaultMaxAttempts = 5
+)
+
+// secretJobKinds are the job kinds whose payload holds a secret, such as an emailed token
+var secretJobKinds = map[string]bool{
+	JobSendPasswordReset: true,
+}
+
+// RunJobScheduler claims and runs due jobs until the context is cancelled. It is safe to run
+// on several server instances at once: each job is claimed by a single worker. The poll
+// interval is read from JOB_POLL_INTERVAL (default 5s).
+func RunJobScheduler(ctx context.Context) {
+	worker := jobWorkerName()
+	periodic := map[string]time.Duration{
+		JobExpirePending: expireInterval,
+		JobPurgeDeleted:  purgeInterval,
+		JobPruneRevoked:  pruneInterval,
+	}
+	for kind, interval := range periodic {
+		if err := ensurePeriodicJob(kind, interval); err != nil {
+			log.Printf("Failed to schedule %s job: %v", kind, err)
+		}
+	}
+
+	ticker := time.NewTicker(durationEnv("JOB_POLL_INTERVAL", 5*time.Second))
+	defer ticker.Stop()
+	log.Printf("Job scheduler started as %s", worker)
+	for {
+		// Keep claiming while full batches come back
+		for {
+			claimed, err := runDueJobs(worker)
+			if err != nil {
+				log.Printf("Failed to claim jobs: %v", err)
+			}
+			if claimed < jobBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Job scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// enqueueJob adds a job running at runAt. A job with the same non-empty key is not added twice.
+func enqueueJob(tx *gorm.DB, kind, key string, payload interface{}, runAt time.Time) error {
+	return enqueueJobAttempts(tx, kind, key, payload, runAt, defaultMaxAttempts)
+}
+
+// enqueueJobAttempts is enqueueJob for jobs that may run up to maxAttempts times.
+func enqueueJobAttempts(tx *gorm.DB, kind, key string, payload interface{}, runAt time.Time, maxAttempts int) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode job payload: %w", err)
+	}
+
+	job := &models.Job{Kind: kind, Payload: string(data), Status: models.JobQueued, RunAt: runAt, MaxAttempts: maxAttempts}
+	if key != "" {
+		job.Key = &key
+	}
+	if err := tx.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(job).Error; err != nil {
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+	return nil
+}
+
+// ensurePeriodicJob queues the single job of a periodic kind unless it exists already.
+func ensurePeriodicJob(kind string, interval time.Duration) error {
+	key := "periodic:" + kind
+	job := &models.Job{Kind: kind, Key: &key, Payload: "{}", Status: models.JobQueued, RunAt: time.Now(), Interval: interval, MaxAttempts: defaultMaxAttempts}
+	return db.DB.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "key"}}, DoNothing: true}).
+		Create(job).Error
+}
+
+// runDueJobs claims a batch of due jobs and runs them, returning how many were claimed.
+func runDueJobs(worker string) (int, error) {
+	jobs, err := claimJobs(worker, jobBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range jobs {
+		err := runJob(&jobs[i])
+		if err := finishJob(&jobs[i], err); err != nil {
+			log.Printf("Failed to record result of job %s: %v", jobs[i].ID, err)
+		}
+	}
+	return len(jobs), nil
+}
+
+// claimJobs locks due jobs, skipping rows other workers hold, and marks them running.
+// Running jobs whose lease expired are claimed again, since their worker is presumed dead.
+func claimJobs(worker string, limit int) ([]models.Job, error) {
+	var jobs []models.Job
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)",
+				models.JobQueued, now, models.JobRunning, now.Add(-jobLease)).
+			Order("run_at").Limit(limit).Find(&jobs).Error; err != nil {
+			return err
+		}
+		if len(jobs) == 0 {
+			return nil
+		}
+
+		ids := make([]uuid.UUID, 0, len(jobs))
+		for i := range jobs {
+			ids = append(ids, jobs[i].ID)
+			jobs[i].Attempts++
+		}
+		return tx.Model(&models.Job{}).Where("id IN ?", ids).Updates(map[string]interface{}{
+			"status":    models.JobRunning,
+			"locked_by": worker,
+			"locked_at": now,
+			"attempts":  gorm.Expr("attempts + 1"),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// runJob runs the handler of the job, turning a panic into an error.
+func runJob(job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	switch job.Kind {
+	case JobSendReminder:
+		return sendBookingReminder(job.Payload)
+	case JobSendNotification:
+		return sendNotification(job.Payload)
+	case JobDeliverWebhook:
+		return deliverWebhook(job.Payload, job.Attempts >= job.MaxAttempts)
+	case JobSendPasswordReset:
+		return sendPasswordReset(job.Payload)
+	case JobExpirePending:
+		_, err := ExpirePendingBookings()
+		return err
+	case JobPurgeDeleted:
+		return PurgeDeletedRecords(durationEnv("PURGE_RETENTION", defaultPurgeRetention))
+	case JobPruneRevoked:
+		_, err := PruneTokenRevocations()
+		return err
+	}
+	return fmt.Errorf("unknown job kind %q", job.Kind)
+}
+
+// finishJob records the outcome of a run. Failed jobs are retried with exponential backoff
+// until they run out of attempts; periodic jobs are always queued for their next period.
+func finishJob(job *models.Job, runErr error) error {
+	now := time.Now()
+	updates := map[string]interface{}{"locked_by": "", "locked_at": nil, "last_error": ""}
+	switch {
+	case runErr == nil && job.Interval > 0:
+		updates["status"] = models.JobQueued
+		updates["run_at"] = now.Add(job.Interval)
+		updates["attempts"] = 0
+	case runErr == nil:
+		updates["status"] = models.JobDone
+	case job.Attempts < job.MaxAttempts:
+		log.Printf("Job %s (%s) failed, attempt %d of %d: %v", job.ID, job.Kind, job.Attempts, job.MaxAttempts, runErr)
+		updates["status"] = models.JobQueued
+		updates["run_at"] = now.Add(jobBackoff(job.Attempts))
+		updates["last_error"] = runErr.Error()
+	case job.Interval > 0:
+		log.Printf("Periodic job %s (%s) failed %d times: %v", job.ID, job.Kind, job.Attempts, runErr)
+		updates["status"] = models.JobQueued
+		updates["run_at"] = now.Add(job.Interval)
+		updates["attempts"] = 0
+		updates["last_error"] = runErr.Error()
+	default:
+		log.Printf("Job %s (%s) failed permanently: %v", job.ID, job.Kind, runErr)
+		updates["status"] = models.JobFailed
+		updates["last_error"] = runErr.Error()
+	}
+
+	// Payloads carrying secrets are dropped once the job will not run again
+	if secretJobKinds[job.Kind] && (updates["status"] == models.JobDone || updates["status"] == models.JobFailed) {
+		updates["payload"] = "{}"
+	}
+
+	// Only the worker still holding the job may record its result
+	return db.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND attempts = ?", job.ID, models.JobRunning, job.Attempts).
+		Updates(updates).Error
+}
+
+// jobBackoff returns the delay before retrying a job that failed the given number of times.
+func jobBackoff(attempts int) time.Duration {
+	delay := jobBaseBackoff
+	for i := 1; i < attempts && delay < jobMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > jobMaxBackoff {
+		delay = jobMaxBackoff
+	}
+	return delay
+}
+
+// PurgeDeletedRecords permanently removes rows soft-deleted more than retention ago. Appointments
+// are only removed once none of their bookings or waitlist entries remain.
+func PurgeDeletedRecords(retention time.Duration) error {
+	cutoff := time.Now().Add(-retention)
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		purgedBookings := tx.Unscoped().Model(&models.Booking{}).Select("id").
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff)
+		if err := tx.Where("booking_id IN (?)", purgedBookings).Delete(&models.BookingHistory{}).Error; err != nil {
+			return fmt.Errorf("failed to purge booking history: %w", err)
+		}
+		if err := tx.Where("booking_id IN (?)", purgedBookings).Delete(&models.BookingTransition{}).Error; err != nil {
+			return fmt.Errorf("failed to purge booking transitions: %w", err)
+		}
+		if err := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Delete(&models.Booking{}).Error; err != nil {
+			return fmt.Errorf("failed to purge bookings: %w", err)
+		}
+		if err := tx.Unscoped().Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Delete(&models.WaitlistEntry{}).Error; err != nil {
+			return fmt.Errorf("failed to purge waitlist entries: %w", err)
+		}
+		if err := tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM bookings WHERE bookings.appointment_id = appointments.id)").
+			Where("NOT EXISTS (SELECT 1 FROM waitlist_entries WHERE waitlist_entries.appointment_id = appointments.id)").
+			Delete(&models.Appointment{}).Error; err != nil {
+			return fmt.Errorf("failed to purge appointments: %w", err)
+		}
+		if err := tx.Unscoped().
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+			Where("NOT EXISTS (SELECT 1 FROM appointments WHERE appointments.series_id = appointment_series.id)").
+			Delete(&models.AppointmentSeries{}).Error; err != nil {
+			return fmt.Errorf("failed to purge appointment series: %w", err)
+		}
+
+		// Events are kept as long as deleted rows, long after every consumer handled them
+		oldEvents := tx.Model(&models.OutboxEvent{}).Select("id").Where("created_at < ?", cutoff)
+		if err := tx.Where("event_id IN (?)", oldEvents).Delete(&models.OutboxDelivery{}).Error; err != nil {
+			return fmt.Errorf("failed to purge outbox deliveries: %w", err)
+		}
+		if err := tx.Where("created_at < ?", cutoff).Delete(&models.OutboxEvent{}).Error; err != nil {
+			return fmt.Errorf("failed to purge outbox events: %w", err)
+		}
+
+		// Sessions and refresh tokens are useless once ended or expired
+		oldSessions := tx.Model(&models.Session{}).Select("id").
+			Where("expires_at < ? OR (ended_at IS NOT NULL AND ended_at < ?)", cutoff, cutoff)
+		if err := tx.Where("expires_at < ? OR family_id IN (?)", cutoff, oldSessions).
+			Delete(&models.RefreshToken{}).Error; err != nil {
+			return fmt.Errorf("failed to purge refresh tokens: %w", err)
+		}
+		if err := tx.Where("expires_at < ? OR (ended_at IS NOT NULL AND ended_at < ?)", cutoff, cutoff).
+			Delete(&models.Session{}).Error; err != nil {
+			return fmt.Errorf("failed to purge sessions: %w", err)
+		}
+		if err := tx.Where("expires_at < ?", cutoff).Delete(&models.PasswordResetToken{}).Error; err != nil {
+			return fmt.Errorf("failed to purge password reset tokens: %w", err)
+		}
+
+		// Finished jobs are kept as long as deleted rows
+		if err := tx.Where("status IN ? AND updated_at < ?", []string{models.JobDone, models.JobFailed}, cutoff).
+			Delete(&models.Job{}).Error; err != nil {
+			return fmt.Errorf("failed to purge jobs: %w", err)
+		}
+		return nil
+	})
+}
+
+// jobWorkerName identifies this process in the jobs it claims.
+func jobWorkerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// durationEnv reads a duration such as "90s" from the environment.
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	if value := os.Getenv(key); value != "" {
+		if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+			return duration
+		}
+		log.Printf("Ignoring invalid %s %q", key, value)
+	}
+	return fallback
+}
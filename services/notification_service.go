@@ -0,0 +1,224 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/notify"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+// JobSendNotification delivers one notification to one user
+const JobSendNotification = "send_notification"
+
+// Notification events, each with its own template.
+const (
+	notifyBookingConfirmed   = "booking_confirmed"
+	notifyBookingPending     = "booking_pending"
+	notifyBookingRequested   = "booking_requested"
+	notifyBookingDeclined    = "booking_declined"
+	notifyBookingCancelled   = "booking_cancelled"
+	notifyBookingRescheduled = "booking_rescheduled"
+	notifyBookingReminder    = "booking_reminder"
+	notifyAppointmentChanged = "appointment_changed"
+)
+
+// notifier delivers rendered notifications; SetNotifier replaces the development default
+var notifier notify.Notifier = &notify.LogNotifier{}
+
+// SetNotifier sets the notifier used to deliver notifications.
+func SetNotifier(n notify.Notifier) {
+	notifier = n
+}
+
+// notificationTemplates hold a "subject" and a "body" template per event.
+var notificationTemplates = map[string]*template.Template{
+	notifyBookingConfirmed: notificationTemplate(notifyBookingConfirmed,
+		`Booking confirmed: {{.Title}}`,
+		`Hi {{.Name}}, your booking for "{{.Title}}" with {{.Other}} on {{.Start}} until {{.End}} is confirmed.`),
+	notifyBookingPending: notificationTemplate(notifyBookingPending,
+		`Booking requested: {{.Title}}`,
+		`Hi {{.Name}}, your booking for "{{.Title}}" on {{.Start}} is waiting for approval by {{.Other}}.`),
+	notifyBookingRequested: notificationTemplate(notifyBookingRequested,
+		`Approval needed: {{.Title}}`,
+		`Hi {{.Name}}, {{.Other}} asked to book "{{.Title}}" on {{.Start}}. Please approve or decline the request.`),
+	notifyBookingDeclined: notificationTemplate(notifyBookingDeclined,
+		`Booking declined: {{.Title}}`,
+		`Hi {{.Name}}, your booking for "{{.Title}}" on {{.Start}} was declined{{if .Reason}}: {{.Reason}}{{end}}.`),
+	notifyBookingCancelled: notificationTemplate(notifyBookingCancelled,
+		`Booking cancelled: {{.Title}}`,
+		`Hi {{.Name}}, the booking{{if .Other}} with {{.Other}}{{end}} for "{{.Title}}" on {{.Start}} was cancelled{{if .Reason}}: {{.Reason}}{{end}}.`),
+	notifyBookingRescheduled: notificationTemplate(notifyBookingRescheduled,
+		`Booking moved: {{.Title}}`,
+		`Hi {{.Name}}, the booking{{if .Other}} with {{.Other}}{{end}} for "{{.Title}}" now takes place on {{.Start}} until {{.End}}.`),
+	notifyBookingReminder: notificationTemplate(notifyBookingReminder,
+		`Reminder: {{.Title}}`,
+		`Hi {{.Name}}, this is a reminder of "{{.Title}}" with {{.Other}} on {{.Start}} until {{.End}}.`),
+	notifyAppointmentChanged: notificationTemplate(notifyAppointmentChanged,
+		`Appointment changed: {{.Title}}`,
+		`Hi {{.Name}}, {{.Other}} changed "{{.Title}}". Your booking is on {{.Start}} until {{.End}}.`),
+}
+
+// notificationPayload is the payload of a notification job. Times are rendered in the
+// recipient's time zone when the notification is sent.
+type notificationPayload struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Event     string    `json:"event"`
+	Title     string    `json:"title"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	Reason    string    `json:"reason,omitempty"`
+	Other     string    `json:"other,omitempty"` // Name of the other party
+}
+
+// notificationTemplate parses the subject and body templates of an event.
+func notificationTemplate(event, subject, body string) *template.Template {
+	return template.Must(template.New(event).Parse(
+		`{{define "subject"}}` + subject + `{{end}}{{define "body"}}` + body + `{{end}}`))
+}
+
+// queueNotification schedules a notification to be sent once the transaction commits.
+func queueNotification(tx *gorm.DB, payload notificationPayload) error {
+	return enqueueJob(tx, JobSendNotification, "", payload, time.Now())
+}
+
+// notifyBooking queues the notifications of a booking event for its participant and its
+// organizer. The user who caused the event, if any, is not told about it, except for
+// participants receiving the outcome of their own booking.
+func notifyBooking(tx *gorm.DB, booking *models.Booking, event, reason string, actor *uuid.UUID) error {
+	var appointment models.Appointment
+	if err := tx.Unscoped().Preload("User").First(&appointment, "id = ?", booking.AppointmentID).Error; err != nil {
+		return fmt.Errorf("failed to load appointment: %w", err)
+	}
+	var participant models.User
+	if err := tx.First(&participant, "id = ?", booking.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load participant: %w", err)
+	}
+
+	base := notificationPayload{
+		Event:     event,
+		Title:     appointment.Title,
+		StartTime: booking.StartTime,
+		EndTime:   booking.EndTime,
+		Reason:    reason,
+	}
+	toParticipant := base
+	toParticipant.UserID = participant.ID
+	toParticipant.Other = appointment.User.Name
+	toOrganizer := base
+	toOrganizer.UserID = appointment.UserID
+	toOrganizer.Other = participant.Name
+
+	actedBy := func(userID uuid.UUID) bool { return actor != nil && *actor == userID }
+
+	var payloads []notificationPayload
+	switch event {
+	case notifyBookingConfirmed, notifyBookingDeclined:
+		payloads = append(payloads, toParticipant)
+	case notifyBookingPending:
+		toOrganizer.Event = notifyBookingRequested
+		payloads = append(payloads, toParticipant, toOrganizer)
+	case notifyBookingCancelled, notifyBookingRescheduled:
+		if !actedBy(participant.ID) {
+			payloads = append(payloads, toParticipant)
+		}
+		if !actedBy(appointment.UserID) && appointment.UserID != participant.ID {
+			payloads = append(payloads, toOrganizer)
+		}
+	case notifyAppointmentChanged:
+		payloads = append(payloads, toParticipant)
+	}
+
+	for _, payload := range payloads {
+		if err := queueNotification(tx, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyAppointmentParticipants tells every participant with an active booking that the
+// organizer changed the appointment.
+func notifyAppointmentParticipants(tx *gorm.DB, appointment *models.Appointment) error {
+	var bookings []models.Booking
+	if err := tx.Where("appointment_id = ? AND status IN ?", appointment.ID,
+		[]string{models.BookingPending, models.BookingConfirmed}).Find(&bookings).Error; err != nil {
+		return fmt.Errorf("failed to load bookings: %w", err)
+	}
+	for i := range bookings {
+		if err := notifyBooking(tx, &bookings[i], notifyAppointmentChanged, "", &appointment.UserID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendNotification is the handler of notification jobs.
+func sendNotification(payload string) error {
+	var notification notificationPayload
+	if err := json.Unmarshal([]byte(payload), &notification); err != nil {
+		return fmt.Errorf("invalid notification payload: %w", err)
+	}
+	return deliverNotification(notification)
+}
+
+// deliverNotification renders the notification in the recipient's time zone and sends it
+// over the channel they prefer.
+func deliverNotification(notification notificationPayload) error {
+	tmpl, ok := notificationTemplates[notification.Event]
+	if !ok {
+		return fmt.Errorf("unknown notification event %q", notification.Event)
+	}
+
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", notification.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.NotifyVia == models.NotifyNone {
+		return nil
+	}
+
+	loc, err := utils.LoadTimeZone(user.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+	const layout = "Mon Jan 2 2006 15:04 MST"
+	data := map[string]string{
+		"Name":   user.Name,
+		"Title":  notification.Title,
+		"Start":  notification.StartTime.In(loc).Format(layout),
+		"End":    notification.EndTime.In(loc).Format(layout),
+		"Reason": notification.Reason,
+		"Other":  notification.Other,
+	}
+
+	var subject, body strings.Builder
+	if err := tmpl.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return fmt.Errorf("failed to render notification: %w", err)
+	}
+	if err := tmpl.ExecuteTemplate(&body, "body", data); err != nil {
+		return fmt.Errorf("failed to render notification: %w", err)
+	}
+
+	channel := notify.ChannelEmail
+	if user.NotifyVia == models.NotifySMS {
+		channel = notify.ChannelSMS
+	}
+	return notifier.Send(notify.Message{
+		Channel: channel,
+		To:      notify.Recipient{Name: user.Name, Email: user.Email, Phone: user.Phone},
+		Subject: subject.String(),
+		Body:    body.String(),
+	})
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// outboxBatchSize is how many events a consumer takes at once
+	outboxBatchSize = 50
+	// outboxMaxAttempts is how often a consumer may fail an event before it gives up on it
+	outboxMaxAttempts = 10
+	// outboxSettleTime is how long after being written an event stays ahead of the cursors.
+	// Positions are taken at insert but become visible at commit, so a slow transaction may
+	// commit an event behind one that was already handled; the cursors wait for that.
+	outboxSettleTime = 5 * time.Minute
+	// outboxCursorScan bounds how many events one cursor advance looks at
+	outboxCursorScan = 1000
+)
+
+// outboxConsumer receives every domain event once. Its handler runs in the transaction that
+// marks the event as delivered to it, so its database effects happen exactly once.
+type outboxConsumer struct {
+	name   string
+	handle func(tx *gorm.DB, event *models.OutboxEvent) error
+}
+
+// outboxConsumers are the subscribers of the outbox
+var outboxConsumers = []outboxConsumer{
+	{name: "webhooks", handle: publishWebhooks},
+	{name: "notifications", handle: publishNotifications},
+	{name: "search", handle: publishSearchIndex},
+}
+
+// recordEvent writes a domain event to the outbox as part of the transaction.
+func recordEvent(tx *gorm.DB, aggregateType string, aggregateID uuid.UUID, event string, actor *uuid.UUID, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	outboxEvent := &models.OutboxEvent{
+		AggregateType: aggregateType,
+		AggregateID:   aggregateID,
+		Event:         event,
+		Payload:       string(payload),
+		ActorID:       actor,
+	}
+	if err := tx.Create(outboxEvent).Error; err != nil {
+		return fmt.Errorf("failed to record event: %w", err)
+	}
+	return nil
+}
+
+// recordAppointmentEvent writes an event of an appointment, caused by its organizer.
+func recordAppointmentEvent(tx *gorm.DB, appointment *models.Appointment, event string, changed ...string) error {
+	data := models.AppointmentEventData{
+		ID:        appointment.ID,
+		Title:     appointment.Title,
+		StartTime: appointment.StartTime,
+		EndTime:   appointment.EndTime,
+		TimeZone:  appointment.TimeZone,
+		UserID:    appointment.UserID,
+		SeriesID:  appointment.SeriesID,
+		Sequence:  appointment.Sequence,
+		Changed:   changed,
+	}
+	return recordEvent(tx, models.AggregateAppointment, appointment.ID, event, &appointment.UserID, data)
+}
+
+// bookingEventData describes the current state of a booking.
+func bookingEventData(booking *models.Booking) models.BookingEventData {
+	return models.BookingEventData{
+		ID:            booking.ID,
+		AppointmentID: booking.AppointmentID,
+		UserID:        booking.UserID,
+		StartTime:     booking.StartTime,
+		EndTime:       booking.EndTime,
+		Status:        booking.Status,
+	}
+}
+
+// recordUserEvent writes an event of a user, caused by that user.
+func recordUserEvent(tx *gorm.DB, user *models.User, event string, changed ...string) error {
+	data := models.UserEventData{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		TimeZone:  user.TimeZone,
+		NotifyVia: user.NotifyVia,
+		Changed:   changed,
+	}
+	return recordEvent(tx, models.AggregateUser, user.ID, event, &user.ID, data)
+}
+
+// RunOutboxDispatcher hands new domain events to every consumer until the context is
+// cancelled. Like the job scheduler it may run on several instances at once. The poll
+// interval is read from OUTBOX_POLL_INTERVAL (default 1s).
+func RunOutboxDispatcher(ctx context.Context) {
+	ticker := time.NewTicker(durationEnv("OUTBOX_POLL_INTERVAL", time.Second))
+	defer ticker.Stop()
+	log.Println("Outbox dispatcher started")
+	for {
+		for _, consumer := range outboxConsumers {
+			// Keep dispatching while full batches come back
+			for {
+				dispatched, err := dispatchOutbox(consumer)
+				if err != nil {
+					log.Printf("Failed to dispatch events to %s: %v", consumer.name, err)
+				}
+				if dispatched < outboxBatchSize {
+					break
+				}
+			}
+			if err := advanceOutboxCursor(consumer.name); err != nil {
+				log.Printf("Failed to advance outbox cursor of %s: %v", consumer.name, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("Outbox dispatcher stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatchOutbox hands a batch of events after the consumer's cursor that it has not handled
+// yet to it and returns how many were taken. Events are locked while handled, so no other
+// instance handles them at the same time, and each handler runs in a savepoint so one failing
+// event does not undo the others.
+func dispatchOutbox(consumer outboxConsumer) (int, error) {
+	var events []models.OutboxEvent
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("position > COALESCE((SELECT position FROM outbox_cursors WHERE consumer = ?), 0)", consumer.name).
+			Where(`NOT EXISTS (SELECT 1 FROM outbox_deliveries WHERE outbox_deliveries.event_id = outbox_events.id
+				AND outbox_deliveries.consumer = ? AND (outbox_deliveries.status <> ? OR outbox_deliveries.retry_at > ?))`,
+				consumer.name, models.OutboxRetrying, now).
+			Order("position").Limit(outboxBatchSize).Find(&events).Error; err != nil {
+			return err
+		}
+
+		for i := range events {
+			// The filter above was evaluated before the lock was granted, so another instance
+			// may have handled the event meanwhile; this read sees what it committed
+			delivery, err := loadOutboxDelivery(tx, consumer.name, events[i].ID)
+			if err != nil {
+				return err
+			}
+			if !outboxDeliveryDue(delivery, now) {
+				continue
+			}
+
+			handleErr := tx.Transaction(func(tx *gorm.DB) error {
+				return consumer.handle(tx, &events[i])
+			})
+			if err := recordOutboxDelivery(tx, delivery, handleErr, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// loadOutboxDelivery returns the delivery of an event to a consumer, or a new one due now if
+// the event was not handed to it yet.
+func loadOutboxDelivery(tx *gorm.DB, consumer string, eventID uuid.UUID) (*models.OutboxDelivery, error) {
+	delivery := models.OutboxDelivery{EventID: eventID, Consumer: consumer}
+	if err := tx.Where(&delivery).Attrs(models.OutboxDelivery{Status: models.OutboxRetrying}).
+		FirstOrInit(&delivery).Error; err != nil {
+		return nil, fmt.Errorf("failed to load outbox delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// outboxDeliveryDue reports whether an event is to be handed to the consumer now: it was not
+// handled yet, or failed and its retry time has come.
+func outboxDeliveryDue(delivery *models.OutboxDelivery, now time.Time) bool {
+	return delivery.Status == models.OutboxRetrying && (delivery.RetryAt == nil || !delivery.RetryAt.After(now))
+}
+
+// recordOutboxDelivery stores the outcome of handing an event to a consumer. Failed events
+// are retried with the job backoff until they run out of attempts.
+func recordOutboxDelivery(tx *gorm.DB, delivery *models.OutboxDelivery, handleErr error, now time.Time) error {
+	consumer, eventID := delivery.Consumer, delivery.EventID
+	delivery.Attempts++
+	delivery.RetryAt = nil
+	delivery.LastError = ""
+	switch {
+	case handleErr == nil:
+		delivery.Status = models.OutboxDone
+	case delivery.Attempts < outboxMaxAttempts:
+		log.Printf("Consumer %s failed event %s, attempt %d of %d: %v", consumer, eventID, delivery.Attempts, outboxMaxAttempts, handleErr)
+		retryAt := now.Add(jobBackoff(delivery.Attempts))
+		delivery.Status = models.OutboxRetrying
+		delivery.RetryAt = &retryAt
+		delivery.LastError = handleErr.Error()
+	default:
+		log.Printf("Consumer %s gave up on event %s: %v", consumer, eventID, handleErr)
+		delivery.Status = models.OutboxFailed
+		delivery.LastError = handleErr.Error()
+	}
+
+	if err := tx.Save(delivery).Error; err != nil {
+		return fmt.Errorf("failed to record outbox delivery: %w", err)
+	}
+	return nil
+}
+
+// outboxCursorEvent is an event after a consumer's cursor, with whether the consumer has
+// finished with it.
+type outboxCursorEvent struct {
+	Position  int64
+	CreatedAt time.Time
+	Finished  bool
+}
+
+// advanceOutboxCursor moves the consumer's cursor past the events it has finished with.
+func advanceOutboxCursor(consumer string) error {
+	cursor := models.OutboxCursor{Consumer: consumer}
+	if err := db.DB.Where(&cursor).FirstOrInit(&cursor).Error; err != nil {
+		return fmt.Errorf("failed to load outbox cursor: %w", err)
+	}
+
+	var events []outboxCursorEvent
+	if err := db.DB.Model(&models.OutboxEvent{}).
+		Select(`position, created_at, EXISTS (SELECT 1 FROM outbox_deliveries WHERE outbox_deliveries.event_id = outbox_events.id
+			AND outbox_deliveries.consumer = ? AND outbox_deliveries.status <> ?) AS finished`, consumer, models.OutboxRetrying).
+		Where("position > ?", cursor.Position).
+		Order("position").Limit(outboxCursorScan).Scan(&events).Error; err != nil {
+		return fmt.Errorf("failed to load outbox events: %w", err)
+	}
+	position := nextOutboxCursor(cursor.Position, events, time.Now().Add(-outboxSettleTime))
+	if position == cursor.Position {
+		return nil
+	}
+
+	cursor.Position = position
+	// Instances may advance concurrently; the cursor never moves back
+	return db.DB.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "consumer"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"position":   gorm.Expr("GREATEST(outbox_cursors.position, excluded.position)"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&cursor).Error
+}
+
+// nextOutboxCursor returns the position the cursor moves to past the leading events, in
+// position order, that are done or failed and were written before settledBefore. An event
+// still being retried holds the cursor back until it runs out of attempts, and so does a
+// recent one, as an event committed late may still appear before it.
+func nextOutboxCursor(position int64, events []outboxCursorEvent, settledBefore time.Time) int64 {
+	for _, event := range events {
+		if !event.Finished || !event.CreatedAt.Before(settledBefore) {
+			break
+		}
+		position = event.Position
+	}
+	return position
+}
+
+// publishNotifications turns booking and appointment events into notifications for the people involved.
+func publishNotifications(tx *gorm.DB, event *models.OutboxEvent) error {
+	switch event.AggregateType {
+	case models.AggregateBooking:
+		var data models.BookingEventData
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return fmt.Errorf("invalid event payload: %w", err)
+		}
+
+		var notification string
+		switch {
+		case event.Event == models.EventBookingCreated && data.Status == models.BookingPending:
+			notification = notifyBookingPending
+		case event.Event == models.EventBookingCreated:
+			notification = notifyBookingConfirmed
+		case event.Event == models.EventBookingUpdated && data.PreviousStartTime != nil:
+			notification = notifyBookingRescheduled
+		case event.Event == models.EventBookingUpdated && data.PreviousStatus == models.BookingPending && data.Status == models.BookingConfirmed:
+			notification = notifyBookingConfirmed
+		case event.Event == models.EventBookingCancelled && data.Status == models.BookingDeclined:
+			notification = notifyBookingDeclined
+		case event.Event == models.EventBookingCancelled:
+			notification = notifyBookingCancelled
+		default:
+			return nil
+		}
+
+		booking := &models.Booking{
+			ID:            data.ID,
+			UserID:        data.UserID,
+			AppointmentID: data.AppointmentID,
+			StartTime:     data.StartTime,
+			EndTime:       data.EndTime,
+		}
+		return notifyBooking(tx, booking, notification, data.Reason, event.ActorID)
+
+	case models.AggregateAppointment:
+		if event.Event != models.EventAppointmentUpdated {
+			return nil
+		}
+		var data models.AppointmentEventData
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return fmt.Errorf("invalid event payload: %w", err)
+		}
+		if len(data.Changed) == 0 {
+			return nil
+		}
+
+		var appointment models.Appointment
+		if err := tx.First(&appointment, "id = ?", data.ID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return fmt.Errorf("failed to load appointment: %w", err)
+		}
+		return notifyAppointmentParticipants(tx, &appointment)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	models "github.com/m13ha/appointment_master/models"
+)
+
+func TestOutboxDeliveryDue(t *testing.T) {
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+
+	tests := []struct {
+		name     string
+		delivery models.OutboxDelivery
+		want     bool
+	}{
+		{"not handed out yet", models.OutboxDelivery{Status: models.OutboxRetrying}, true},
+		{"retry due", models.OutboxDelivery{Status: models.OutboxRetrying, Attempts: 1, RetryAt: at(-time.Second)}, true},
+		{"retry due right now", models.OutboxDelivery{Status: models.OutboxRetrying, Attempts: 1, RetryAt: at(0)}, true},
+		{"retry later", models.OutboxDelivery{Status: models.OutboxRetrying, Attempts: 1, RetryAt: at(time.Minute)}, false},
+		// Another instance handled the event between the batch query and the lock
+		{"done meanwhile", models.OutboxDelivery{Status: models.OutboxDone, Attempts: 1}, false},
+		{"given up", models.OutboxDelivery{Status: models.OutboxFailed, Attempts: outboxMaxAttempts}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outboxDeliveryDue(&tt.delivery, now); got != tt.want {
+				t.Errorf("outboxDeliveryDue = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextOutboxCursor(t *testing.T) {
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	settledBefore := now.Add(-outboxSettleTime)
+	old := now.Add(-time.Hour)
+	recent := now.Add(-time.Minute)
+
+	tests := []struct {
+		name     string
+		position int64
+		events   []outboxCursorEvent
+		want     int64
+	}{
+		{
+			name:     "no new events",
+			position: 7,
+			want:     7,
+		},
+		{
+			name:     "all finished and settled",
+			position: 7,
+			events:   []outboxCursorEvent{{8, old, true}, {9, old, true}, {12, old, true}},
+			want:     12,
+		},
+		{
+			name:     "stops before a retrying event",
+			position: 7,
+			events:   []outboxCursorEvent{{8, old, true}, {9, old, false}, {10, old, true}},
+			want:     8,
+		},
+		{
+			name:     "first event still retrying",
+			position: 7,
+			events:   []outboxCursorEvent{{8, old, false}, {9, old, true}},
+			want:     7,
+		},
+		{
+			name:     "stops before a recent event",
+			position: 7,
+			events:   []outboxCursorEvent{{8, old, true}, {9, recent, true}, {10, old, true}},
+			want:     8,
+		},
+		{
+			name:     "event written exactly at the settle time",
+			position: 7,
+			events:   []outboxCursorEvent{{8, settledBefore, true}},
+			want:     7,
+		},
+		{
+			name:     "position gaps are skipped",
+			position: 0,
+			events:   []outboxCursorEvent{{3, old, true}, {5, old, true}, {6, recent, false}},
+			want:     5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextOutboxCursor(tt.position, tt.events, settledBefore); got != tt.want {
+				t.Errorf("nextOutboxCursor = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/auth"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/notify"
+	"github.com/m13ha/appointment_master/utils"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobSendPasswordReset emails a password reset token
+const JobSendPasswordReset = "send_password_reset"
+
+const (
+	// defaultPasswordResetTTL is how long a reset token stays valid when PASSWORD_RESET_TTL is not set
+	defaultPasswordResetTTL = time.Hour
+	// passwordResetCooldown is how long after a reset email no further one is sent to the same user
+	passwordResetCooldown = time.Minute
+	// minPasswordLength is the fewest characters a new password may have
+	minPasswordLength = 8
+	// maxPasswordBytes is the longest password bcrypt can hash
+	maxPasswordBytes = 72
+)
+
+// RequestPasswordReset emails a single-use reset token to the user with the given email. The
+// outcome is the same whether or not the email belongs to a user, so callers cannot probe
+// for accounts. The email is queued as a job and sent through the notifier's email channel.
+// PASSWORD_RESET_TTL sets how long the token is valid (default 1h), and PASSWORD_RESET_URL
+// the page the emailed link points to.
+func RequestPasswordReset(email string) error {
+	if email == "" {
+		return fmt.Errorf("email is required")
+	}
+
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		// Serialize requests of the user so the cooldown holds
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, "id = ?", user.ID).Error; err != nil {
+			return fmt.Errorf("failed to lock user: %w", err)
+		}
+
+		now := time.Now()
+		var recent int64
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND created_at > ?", user.ID, now.Add(-passwordResetCooldown)).
+			Count(&recent).Error; err != nil {
+			return fmt.Errorf("failed to check reset tokens: %w", err)
+		}
+		if recent > 0 {
+			return nil
+		}
+
+		// Only the latest token works
+		if err := expirePasswordResetTokens(tx, &user, now); err != nil {
+			return err
+		}
+
+		generated, err := utils.GenerateSecretToken()
+		if err != nil {
+			return fmt.Errorf("failed to generate reset token: %w", err)
+		}
+		resetToken := &models.PasswordResetToken{
+			UserID:    user.ID,
+			TokenHash: utils.HashSecretToken(generated),
+			ExpiresAt: now.Add(durationEnv("PASSWORD_RESET_TTL", defaultPasswordResetTTL)),
+		}
+		if err := tx.Create(resetToken).Error; err != nil {
+			return fmt.Errorf("failed to store reset token: %w", err)
+		}
+		// Only the sealed token is queued, so the jobs table never holds a usable one
+		sealed, err := auth.Seal(generated)
+		if err != nil {
+			return fmt.Errorf("failed to seal reset token: %w", err)
+		}
+		return enqueueJob(tx, JobSendPasswordReset, "", passwordResetPayload{TokenID: resetToken.ID, SealedToken: sealed}, now)
+	})
+}
+
+// ConfirmPasswordReset sets a new password with a reset token. The token is used up, and
+// every session of the user is ended so the old password's logins stop working.
+func ConfirmPasswordReset(token, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	if token == "" {
+		return fmt.Errorf("invalid or expired reset token")
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var resetToken models.PasswordResetToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", utils.HashSecretToken(token)).First(&resetToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("invalid or expired reset token")
+			}
+			return fmt.Errorf("failed to load reset token: %w", err)
+		}
+
+		now := time.Now()
+		if resetToken.UsedAt != nil || !now.Before(resetToken.ExpiresAt) {
+			return fmt.Errorf("invalid or expired reset token")
+		}
+
+		var user models.User
+		if err := tx.First(&user, "id = ?", resetToken.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("invalid or expired reset token")
+			}
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"hashed_password": string(hashedPassword),
+			"token_version":   gorm.Expr("token_version + 1"),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update password: %w", err)
+		}
+		if err := expirePasswordResetTokens(tx, &user, now); err != nil {
+			return err
+		}
+		if err := endUserSessions(tx, user.ID, now); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, &user, models.EventUserUpdated, "password")
+	})
+}
+
+// passwordResetPayload is the payload of a reset email job. The token is encrypted with
+// auth.Seal, and the scheduler drops it once the job finished.
+type passwordResetPayload struct {
+	TokenID     uuid.UUID `json:"token_id"`
+	SealedToken string    `json:"sealed_token"`
+}
+
+// validatePassword checks a password set through a reset: at least 8 characters, and at most
+// the 72 bytes bcrypt hashes.
+func validatePassword(password string) error {
+	if password == "" {
+		return fmt.Errorf("password is required")
+	}
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least 8 characters")
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("password must be at most 72 bytes")
+	}
+	return nil
+}
+
+// expirePasswordResetTokens uses up the unused reset tokens of the user.
+func expirePasswordResetTokens(tx *gorm.DB, user *models.User, now time.Time) error {
+	if err := tx.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Update("used_at", now).Error; err != nil {
+		return fmt.Errorf("failed to expire reset tokens: %w", err)
+	}
+	return nil
+}
+
+// sendPasswordReset is the handler of reset email jobs. It emails the reset token to the user,
+// whatever channel they prefer for notifications, since they asked for it. Tokens that were
+// used or replaced in the meantime are not sent.
+func sendPasswordReset(payload string) error {
+	var reset passwordResetPayload
+	if err := json.Unmarshal([]byte(payload), &reset); err != nil {
+		return fmt.Errorf("invalid password reset payload: %w", err)
+	}
+
+	var resetToken models.PasswordResetToken
+	if err := db.DB.First(&resetToken, "id = ?", reset.TokenID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if resetToken.UsedAt != nil || !time.Now().Before(resetToken.ExpiresAt) {
+		return nil
+	}
+	token, err := auth.Open(reset.SealedToken)
+	if err != nil {
+		return fmt.Errorf("failed to open reset token: %w", err)
+	}
+	if resetToken.TokenHash != utils.HashSecretToken(token) {
+		return nil
+	}
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", resetToken.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	expiresAt := resetToken.ExpiresAt
+
+	loc, err := utils.LoadTimeZone(user.TimeZone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s, a password reset was requested for your account.\n\n", user.Name)
+	if base := os.Getenv("PASSWORD_RESET_URL"); base != "" {
+		separator := "?"
+		if strings.Contains(base, "?") {
+			separator = "&"
+		}
+		fmt.Fprintf(&body, "Set a new password at %s%stoken=%s\n", base, separator, url.QueryEscape(token))
+	} else {
+		fmt.Fprintf(&body, "Your reset token is %s\n", token)
+	}
+	fmt.Fprintf(&body, "\nIt can be used once until %s. If you did not ask for it, ignore this email; your password stays unchanged.",
+		expiresAt.In(loc).Format("Mon Jan 2 2006 15:04 MST"))
+
+	return notifier.Send(notify.Message{
+		Channel: notify.ChannelEmail,
+		To:      notify.Recipient{Name: user.Name, Email: user.Email},
+		Subject: "Reset your password",
+		Body:    body.String(),
+	})
+}
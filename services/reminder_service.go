@@ -0,0 +1,102 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+)
+
+// defaultReminderOffsets is used when REMINDER_OFFSETS is not set
+var defaultReminderOffsets = []time.Duration{24 * time.Hour, time.Hour}
+
+// reminderPayload is the payload of a reminder job. The start time lets a reminder for a
+// booking that was since rescheduled recognise itself as stale.
+type reminderPayload struct {
+	BookingID uuid.UUID `json:"booking_id"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// reminderOffsets reads how long before a booking reminders go out from the comma separated
+// REMINDER_OFFSETS environment variable, e.g. "24h,1h".
+func reminderOffsets() []time.Duration {
+	value := os.Getenv("REMINDER_OFFSETS")
+	if value == "" {
+		return defaultReminderOffsets
+	}
+
+	var offsets []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		offset, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || offset <= 0 {
+			log.Printf("Ignoring invalid reminder offset %q", part)
+			continue
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// scheduleBookingReminders queues a reminder job for every offset still ahead of the booking.
+func scheduleBookingReminders(tx *gorm.DB, booking *models.Booking) error {
+	now := time.Now()
+	for _, offset := range reminderOffsets() {
+		runAt := booking.StartTime.Add(-offset)
+		if runAt.Before(now) {
+			continue
+		}
+		key := fmt.Sprintf("reminder:%s:%d:%d", booking.ID, int64(offset.Seconds()), booking.StartTime.Unix())
+		payload := reminderPayload{BookingID: booking.ID, StartTime: booking.StartTime}
+		if err := enqueueJob(tx, JobSendReminder, key, payload, runAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cancelBookingReminders drops the reminders of a booking that have not run yet.
+func cancelBookingReminders(tx *gorm.DB, bookingID uuid.UUID) error {
+	if err := tx.Where("kind = ? AND status = ? AND key LIKE ?", JobSendReminder, models.JobQueued, "reminder:"+bookingID.String()+":%").
+		Delete(&models.Job{}).Error; err != nil {
+		return fmt.Errorf("failed to cancel reminders: %w", err)
+	}
+	return nil
+}
+
+// sendBookingReminder reminds the participant of a confirmed booking over their preferred
+// channel. Reminders for bookings that were cancelled or moved in the meantime are dropped.
+func sendBookingReminder(payload string) error {
+	var reminder reminderPayload
+	if err := json.Unmarshal([]byte(payload), &reminder); err != nil {
+		return fmt.Errorf("invalid reminder payload: %w", err)
+	}
+
+	var booking models.Booking
+	if err := db.DB.Preload("Appointment.User").
+		First(&booking, "id = ?", reminder.BookingID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if booking.Status != models.BookingConfirmed || !booking.StartTime.Equal(reminder.StartTime) {
+		return nil
+	}
+
+	return deliverNotification(notificationPayload{
+		UserID:    booking.UserID,
+		Event:     notifyBookingReminder,
+		Title:     booking.Appointment.Title,
+		StartTime: booking.StartTime,
+		EndTime:   booking.EndTime,
+		Other:     booking.Appointment.User.Name,
+	})
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// searchResultLimit caps the results of a search
+const searchResultLimit = 50
+
+// publishSearchIndex keeps the search index of appointments in step with their events.
+func publishSearchIndex(tx *gorm.DB, event *models.OutboxEvent) error {
+	if event.AggregateType != models.AggregateAppointment {
+		return nil
+	}
+	var data models.AppointmentEventData
+	if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+		return fmt.Errorf("invalid event payload: %w", err)
+	}
+
+	if event.Event == models.EventAppointmentCancelled {
+		if err := tx.Delete(&models.SearchDocument{}, "id = ?", data.ID).Error; err != nil {
+			return fmt.Errorf("failed to remove search document: %w", err)
+		}
+		return nil
+	}
+
+	document := models.SearchDocument{
+		ID:        data.ID,
+		Kind:      models.AggregateAppointment,
+		UserID:    data.UserID,
+		Title:     data.Title,
+		StartTime: data.StartTime,
+		Sequence:  data.Sequence,
+	}
+	// A late event must not overwrite a newer revision
+	if err := tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"title", "start_time", "sequence", "updated_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "search_documents.sequence <= excluded.sequence"}}},
+	}).Create(&document).Error; err != nil {
+		return fmt.Errorf("failed to index appointment: %w", err)
+	}
+	return nil
+}
+
+// SearchAppointments finds the user's appointments whose title contains the words of the
+// query, using the search index.
+func SearchAppointments(userID uuid.UUID, query string) ([]models.Appointment, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("search query is required")
+	}
+
+	matches := db.DB.Model(&models.SearchDocument{}).Select("id").
+		Where("user_id = ? AND kind = ?", userID, models.AggregateAppointment).
+		Where("to_tsvector('simple', title) @@ plainto_tsquery('simple', ?)", query)
+
+	var appointments []models.Appointment
+	if err := db.DB.Where("id IN (?)", matches).Order("start_time").
+		Limit(searchResultLimit).Find(&appointments).Error; err != nil {
+		return nil, err
+	}
+	return appointments, nil
+}
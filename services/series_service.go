@@ -0,0 +1,390 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+// CreateRecurringAppointment expands the request's recurrence rule and creates one appointment
+// per occurrence, all validated against the organizer's other appointments like CreateAppointment.
+// Either every occurrence is created or none is.
+func CreateRecurringAppointment(req models.AppointmentRequest) (*models.AppointmentSeries, []models.Appointment, error) {
+	var series *models.AppointmentSeries
+	var appointments []models.Appointment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		series, appointments, err = createRecurringAppointment(tx, req)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return series, appointments, nil
+}
+
+// createRecurringAppointment saves the series and its occurrences inside the given transaction.
+func createRecurringAppointment(tx *gorm.DB, req models.AppointmentRequest) (*models.AppointmentSeries, []models.Appointment, error) {
+	if err := defaultTimeZone(tx, &req); err != nil {
+		return nil, nil, err
+	}
+
+	base := newAppointment(req)
+	if err := validateAppointment(base); err != nil {
+		return nil, nil, err
+	}
+
+	// Occurrences keep the wall clock time of the first one in the appointment's zone
+	loc, _ := utils.LoadTimeZone(base.TimeZone)
+	rule, err := utils.ParseRRule(req.RRule, loc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recurrence rule: %v", err)
+	}
+	starts, err := rule.Occurrences(req.StartTime.In(loc), req.ExDates)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recurrence rule: %v", err)
+	}
+	if len(starts) == 0 {
+		return nil, nil, fmt.Errorf("recurrence produces no occurrences")
+	}
+
+	series := &models.AppointmentSeries{
+		UserID:    req.UserID,
+		RRule:     rule.String(),
+		StartTime: req.StartTime.UTC(),
+		TimeZone:  base.TimeZone,
+	}
+	setSeriesExDates(series, req.ExDates)
+	if err := tx.Create(series).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to create appointment series: %w", err)
+	}
+
+	hours, err := loadAvailability(tx, req.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var appointments []models.Appointment
+	length := req.EndTime.Sub(req.StartTime)
+	for _, start := range starts {
+		recurrenceID := start.UTC()
+		occurrence := *base
+		occurrence.AppCode = utils.GenerateAppCode()
+		occurrence.StartTime = start.UTC()
+		occurrence.EndTime = start.Add(length).UTC()
+		occurrence.SeriesID = &series.ID
+		occurrence.RecurrenceID = &recurrenceID
+
+		if !hours.covers(occurrence.StartTime, occurrence.EndTime) {
+			return nil, nil, fmt.Errorf("outside organizer availability")
+		}
+		// Earlier occurrences are visible to the check inside the transaction
+		if err := checkAppointmentOverlap(tx, &occurrence); err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Create(&occurrence).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to create appointment: %w", err)
+		}
+		if err := recordAppointmentEvent(tx, &occurrence, models.EventAppointmentCreated); err != nil {
+			return nil, nil, err
+		}
+		appointments = append(appointments, occurrence)
+	}
+
+	return series, appointments, nil
+}
+
+// UpdateAppointmentSeries applies the changes to this and the following occurrences, or to all
+// occurrences, of the series the appointment belongs to. Time changes are applied as a shift
+// relative to the edited occurrence. Editing the following occurrences splits them into a new series.
+func UpdateAppointmentSeries(appointmentID string, userID uuid.UUID, req models.AppointmentUpdateRequest, scope string) ([]models.Appointment, error) {
+	var targets []models.Appointment
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		anchor, series, err := lockSeriesOccurrence(tx, appointmentID, userID)
+		if err != nil {
+			return err
+		}
+
+		// Shifts are wall clock offsets in the series zone, so they survive daylight saving changes
+		loc := seriesLocation(series)
+		var startShift, endShift time.Duration
+		if req.StartTime != nil {
+			startShift = utils.WallClockDiff(*req.StartTime, anchor.StartTime, loc)
+		}
+		if req.EndTime != nil {
+			endShift = utils.WallClockDiff(*req.EndTime, anchor.EndTime, loc)
+		}
+
+		targets, err = lockSeriesTargets(tx, series, anchor, scope)
+		if err != nil {
+			return err
+		}
+
+		if scope == models.ScopeFollowing && !series.StartTime.Equal(*anchor.RecurrenceID) {
+			if series, err = splitSeries(tx, series, *anchor.RecurrenceID); err != nil {
+				return err
+			}
+		}
+		if err := shiftSeries(tx, series, startShift); err != nil {
+			return err
+		}
+
+		excludeIDs := make([]uuid.UUID, 0, len(targets))
+		for _, target := range targets {
+			excludeIDs = append(excludeIDs, target.ID)
+		}
+
+		for i := range targets {
+			target := &targets[i]
+			occurrenceReq := req
+			occurrenceStart := utils.ShiftWallClock(target.StartTime, startShift, loc).UTC()
+			occurrenceEnd := utils.ShiftWallClock(target.EndTime, endShift, loc).UTC()
+			occurrenceReq.StartTime = &occurrenceStart
+			occurrenceReq.EndTime = &occurrenceEnd
+
+			target.SeriesID = &series.ID
+			if target.RecurrenceID != nil {
+				recurrenceID := utils.ShiftWallClock(*target.RecurrenceID, startShift, loc).UTC()
+				target.RecurrenceID = &recurrenceID
+			}
+			if err := updateAppointment(tx, target, occurrenceReq, excludeIDs...); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return targets, nil
+}
+
+// CancelAppointmentSeries cancels this and the following occurrences, or all occurrences, of the
+// series the appointment belongs to, cascading to their bookings like CancelAppointment.
+func CancelAppointmentSeries(appointmentID string, userID uuid.UUID, reason, scope string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		anchor, series, err := lockSeriesOccurrence(tx, appointmentID, userID)
+		if err != nil {
+			return err
+		}
+
+		targets, err := lockSeriesTargets(tx, series, anchor, scope)
+		if err != nil {
+			return err
+		}
+		for i := range targets {
+			if err := cancelAppointment(tx, &targets[i], reason); err != nil {
+				return err
+			}
+		}
+
+		// The series ends before the anchor, or disappears when nothing is left of it
+		if scope == models.ScopeFollowing && !series.StartTime.Equal(*anchor.RecurrenceID) {
+			return trimSeries(tx, series, *anchor.RecurrenceID)
+		}
+		if err := tx.Delete(series).Error; err != nil {
+			return fmt.Errorf("failed to cancel appointment series: %w", err)
+		}
+		return nil
+	})
+}
+
+// lockSeriesOccurrence locks an occurrence owned by the user and loads its series.
+func lockSeriesOccurrence(tx *gorm.DB, appointmentID string, userID uuid.UUID) (*models.Appointment, *models.AppointmentSeries, error) {
+	anchor, err := lockOwnedAppointment(tx, appointmentID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if anchor.SeriesID == nil || anchor.RecurrenceID == nil {
+		return nil, nil, fmt.Errorf("appointment is not recurring")
+	}
+
+	var series models.AppointmentSeries
+	if err := tx.First(&series, "id = ?", *anchor.SeriesID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, fmt.Errorf("appointment is not recurring")
+		}
+		return nil, nil, fmt.Errorf("failed to load appointment series: %w", err)
+	}
+	return anchor, &series, nil
+}
+
+// lockSeriesTargets locks the occurrences affected by an edit with the given scope, in start order.
+func lockSeriesTargets(tx *gorm.DB, series *models.AppointmentSeries, anchor *models.Appointment, scope string) ([]models.Appointment, error) {
+	var ids []uuid.UUID
+	query := tx.Model(&models.Appointment{}).Where("series_id = ?", series.ID)
+	switch scope {
+	case models.ScopeAll:
+	case models.ScopeFollowing:
+		query = query.Where("recurrence_id >= ?", *anchor.RecurrenceID)
+	default:
+		return nil, fmt.Errorf("invalid edit scope")
+	}
+	if err := query.Order("start_time").Pluck("id", &ids).Error; err != nil {
+		return nil, fmt.Errorf("failed to load appointment series: %w", err)
+	}
+
+	targets := make([]models.Appointment, 0, len(ids))
+	for _, id := range ids {
+		target, err := lockAppointment(tx, id.String())
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, *target)
+	}
+	return targets, nil
+}
+
+// splitSeries ends the series before the given occurrence and moves the rest of its rule into
+// a new series starting at that occurrence.
+func splitSeries(tx *gorm.DB, series *models.AppointmentSeries, from time.Time) (*models.AppointmentSeries, error) {
+	loc := seriesLocation(series)
+	rule, err := utils.ParseRRule(series.RRule, loc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse appointment series rule: %w", err)
+	}
+
+	// COUNT covers the whole rule, so the new series keeps only what was left of it
+	if rule.Count > 0 {
+		previous, err := rule.Occurrences(series.StartTime.In(loc), nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand appointment series rule: %w", err)
+		}
+		remaining := 0
+		for _, start := range previous {
+			if !start.Before(from) {
+				remaining++
+			}
+		}
+		rule.Count = remaining
+	}
+
+	var later []time.Time
+	for _, exdate := range SeriesExDates(series) {
+		if !exdate.Before(from) {
+			later = append(later, exdate)
+		}
+	}
+
+	next := &models.AppointmentSeries{
+		UserID:    series.UserID,
+		RRule:     rule.String(),
+		StartTime: from,
+		TimeZone:  series.TimeZone,
+	}
+	setSeriesExDates(next, later)
+	if err := tx.Create(next).Error; err != nil {
+		return nil, fmt.Errorf("failed to create appointment series: %w", err)
+	}
+
+	if err := trimSeries(tx, series, from); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// trimSeries ends the series rule just before the given occurrence.
+func trimSeries(tx *gorm.DB, series *models.AppointmentSeries, before time.Time) error {
+	rule, err := utils.ParseRRule(series.RRule, seriesLocation(series))
+	if err != nil {
+		return fmt.Errorf("failed to parse appointment series rule: %w", err)
+	}
+	rule.Count = 0
+	rule.Until = before.Add(-time.Second)
+	series.RRule = rule.String()
+
+	var earlier []time.Time
+	for _, exdate := range SeriesExDates(series) {
+		if exdate.Before(before) {
+			earlier = append(earlier, exdate)
+		}
+	}
+	setSeriesExDates(series, earlier)
+
+	if err := tx.Save(series).Error; err != nil {
+		return fmt.Errorf("failed to update appointment series: %w", err)
+	}
+	return nil
+}
+
+// shiftSeries moves the start and the exclusions of the series by the given wall clock offset.
+func shiftSeries(tx *gorm.DB, series *models.AppointmentSeries, shift time.Duration) error {
+	if shift == 0 {
+		return nil
+	}
+
+	loc := seriesLocation(series)
+	exdates := SeriesExDates(series)
+	for i := range exdates {
+		exdates[i] = utils.ShiftWallClock(exdates[i], shift, loc).UTC()
+	}
+	setSeriesExDates(series, exdates)
+	series.StartTime = utils.ShiftWallClock(series.StartTime, shift, loc).UTC()
+
+	if err := tx.Save(series).Error; err != nil {
+		return fmt.Errorf("failed to update appointment series: %w", err)
+	}
+	return nil
+}
+
+// excludeFromSeries records an occurrence as an exclusion of its series.
+func excludeFromSeries(tx *gorm.DB, seriesID uuid.UUID, recurrenceID time.Time) error {
+	var series models.AppointmentSeries
+	if err := tx.First(&series, "id = ?", seriesID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to load appointment series: %w", err)
+	}
+
+	setSeriesExDates(&series, append(SeriesExDates(&series), recurrenceID))
+	if err := tx.Save(&series).Error; err != nil {
+		return fmt.Errorf("failed to update appointment series: %w", err)
+	}
+	return nil
+}
+
+// seriesLocation returns the zone the series rule is expanded in.
+func seriesLocation(series *models.AppointmentSeries) *time.Location {
+	loc, err := utils.LoadTimeZone(series.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// SeriesExDates returns the occurrence start times excluded from the series.
+func SeriesExDates(series *models.AppointmentSeries) []time.Time {
+	if series.ExDates == "" {
+		return nil
+	}
+
+	var exdates []time.Time
+	for _, value := range strings.Split(series.ExDates, ",") {
+		if exdate, err := utils.ParseICalTime(value, time.UTC); err == nil {
+			exdates = append(exdates, exdate)
+		}
+	}
+	return exdates
+}
+
+// setSeriesExDates stores the excluded occurrence start times on the series.
+func setSeriesExDates(series *models.AppointmentSeries, exdates []time.Time) {
+	sort.Slice(exdates, func(i, j int) bool { return exdates[i].Before(exdates[j]) })
+
+	values := make([]string, 0, len(exdates))
+	for _, exdate := range exdates {
+		values = append(values, utils.FormatICalTime(exdate))
+	}
+	series.ExDates = strings.Join(values, ",")
+}
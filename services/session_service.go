@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sessionTouchInterval is how often a session's last seen time is updated while it is in use
+const sessionTouchInterval = time.Minute
+
+// StartSession records a new login session of the user from the given device and issues its
+// first refresh token.
+func StartSession(user *models.User, userAgent, ip string) (*models.SessionTokens, error) {
+	tokens := &models.SessionTokens{User: user}
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		session := &models.Session{
+			UserID:     user.ID,
+			UserAgent:  userAgent,
+			IP:         ip,
+			ExpiresAt:  now.Add(durationEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)),
+			LastSeenAt: now,
+		}
+		if err := tx.Create(session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		token, _, err := issueRefreshToken(tx, user.ID, session.ID, session.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		tokens.SessionID = session.ID
+		tokens.RefreshToken = token
+		tokens.RefreshExpiresAt = session.ExpiresAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetSessions lists the active sessions of the user, most recently used first.
+func GetSessions(userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	if err := db.DB.Where("user_id = ? AND ended_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// EndSession terminates an active session of the user. Its refresh tokens stop working and
+// its access tokens are rejected from then on.
+func EndSession(sessionID string, userID uuid.UUID) error {
+	id, err := uuid.Parse(sessionID)
+	if err != nil {
+		return fmt.Errorf("session not found")
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var session models.Session
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ? AND ended_at IS NULL", id, userID).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("session not found")
+			}
+			return fmt.Errorf("failed to load session: %w", err)
+		}
+		return endSession(tx, session.ID, time.Now())
+	})
+}
+
+// endSession marks the session as ended and revokes its refresh tokens.
+func endSession(tx *gorm.DB, sessionID uuid.UUID, now time.Time) error {
+	if err := tx.Model(&models.Session{}).Where("id = ? AND ended_at IS NULL", sessionID).
+		Update("ended_at", now).Error; err != nil {
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+	return revokeTokenFamily(tx, sessionID, now)
+}
+
+// endUserSessions ends every active session of the user.
+func endUserSessions(tx *gorm.DB, userID uuid.UUID, now time.Time) error {
+	if err := tx.Model(&models.Session{}).Where("user_id = ? AND ended_at IS NULL", userID).
+		Update("ended_at", now).Error; err != nil {
+		return fmt.Errorf("failed to end sessions: %w", err)
+	}
+	if err := tx.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", now).Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// touchSession updates the last seen time of a session at most once per sessionTouchInterval.
+// Failures are only logged since they must not fail the request.
+func touchSession(sessionID uuid.UUID) {
+	now := time.Now()
+	if err := db.DB.Model(&models.Session{}).
+		Where("id = ? AND last_seen_at < ?", sessionID, now.Add(-sessionTouchInterval)).
+		Update("last_seen_at", now).Error; err != nil {
+		log.Printf("Failed to update session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,137 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+)
+
+const (
+	// minSlotDuration is the shortest slot an appointment may be split into
+	minSlotDuration = time.Minute
+	// maxSlotsPerAppointment bounds how many slots an appointment may be split into
+	maxSlotsPerAppointment = 1000
+)
+
+// GenerateSlots splits the appointment window into slots of Duration length, separated by the
+// buffers kept after and before each session. An appointment without a duration is a single
+// slot covering the whole window, and a trailing remainder shorter than Duration is not bookable.
+func GenerateSlots(appointment *models.Appointment) []models.Slot {
+	if appointment.Duration <= 0 {
+		return []models.Slot{{StartTime: appointment.StartTime, EndTime: appointment.EndTime, Status: models.SlotFree}}
+	}
+
+	count := slotCount(appointment)
+	if count > maxSlotsPerAppointment {
+		count = maxSlotsPerAppointment
+	}
+	slots := make([]models.Slot, 0, count)
+	step := appointment.Duration + appointment.BufferAfter + appointment.BufferBefore
+	start := appointment.StartTime
+	for i := 0; i < count; i++ {
+		slots = append(slots, models.Slot{StartTime: start, EndTime: start.Add(appointment.Duration), Status: models.SlotFree})
+		start = start.Add(step)
+	}
+	return slots
+}
+
+// slotCount returns how many slots GenerateSlots splits the appointment into.
+func slotCount(appointment *models.Appointment) int {
+	if appointment.Duration <= 0 {
+		return 1
+	}
+	window := appointment.EndTime.Sub(appointment.StartTime)
+	if window < appointment.Duration {
+		return 0
+	}
+	step := appointment.Duration + appointment.BufferAfter + appointment.BufferBefore
+	return int((window-appointment.Duration)/step) + 1
+}
+
+// slotKey identifies a slot by the instants it starts and ends at.
+type slotKey struct {
+	start, end int64
+}
+
+func newSlotKey(start, end time.Time) slotKey {
+	return slotKey{start: start.UnixNano(), end: end.UnixNano()}
+}
+
+// slotKeys returns the set of start and end pairs of the slots.
+func slotKeys(slots []models.Slot) map[slotKey]bool {
+	keys := make(map[slotKey]bool, len(slots))
+	for _, slot := range slots {
+		keys[newSlotKey(slot.StartTime, slot.EndTime)] = true
+	}
+	return keys
+}
+
+// GetAppointmentSlots retrieves the slots of an appointment with their free/taken status.
+func GetAppointmentSlots(appointmentID string) ([]models.Slot, error) {
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, "id = ?", appointmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		return nil, err
+	}
+
+	return slotsWithStatus(db.DB, &appointment)
+}
+
+// slotsWithStatus generates the appointment slots with their booking counts. A slot is closed
+// outside the booking window, and taken once it reaches the slot capacity or once the
+// appointment reaches its total capacity.
+func slotsWithStatus(tx *gorm.DB, appointment *models.Appointment) ([]models.Slot, error) {
+	var bookings []models.Booking
+	if err := tx.Where("appointment_id = ?", appointment.ID).Find(&bookings).Error; err != nil {
+		return nil, fmt.Errorf("failed to load bookings: %w", err)
+	}
+
+	booked := make(map[time.Time]int, len(bookings))
+	for _, booking := range bookings {
+		booked[booking.StartTime.UTC()]++
+	}
+	appointmentFull := appointment.Capacity > 0 && len(bookings) >= appointment.Capacity
+
+	now := time.Now()
+	slots := GenerateSlots(appointment)
+	for i := range slots {
+		slots[i].Booked = booked[slots[i].StartTime.UTC()]
+		slots[i].Capacity = appointment.SlotCapacity
+		switch {
+		case checkBookingWindow(appointment, slots[i].StartTime, now) != nil:
+			slots[i].Status = models.SlotClosed
+		case appointmentFull || slots[i].Booked >= slots[i].Capacity:
+			slots[i].Status = models.SlotTaken
+		}
+	}
+	return slots, nil
+}
+
+// checkBookingWindow reports whether a slot starting at start can no longer, or not yet, be
+// booked at now because of the appointment's minimum notice and maximum advance. Without a
+// minimum notice bookings close when the slot starts.
+func checkBookingWindow(appointment *models.Appointment, start, now time.Time) error {
+	if start.Before(now.Add(appointment.MinNotice)) {
+		return fmt.Errorf("booking notice is too short")
+	}
+	if appointment.MaxAdvance > 0 && start.After(now.Add(appointment.MaxAdvance)) {
+		return fmt.Errorf("booking is too far in advance")
+	}
+	return nil
+}
+
+// findSlot returns the slot exactly matching the given range, if any.
+func findSlot(slots []models.Slot, start, end time.Time) (models.Slot, bool) {
+	for _, slot := range slots {
+		if slot.StartTime.Equal(start) && slot.EndTime.Equal(end) {
+			return slot, true
+		}
+	}
+	return models.Slot{}, false
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	models "github.com/m13ha/appointment_master/models"
+)
+
+func TestSlotKeysMatchWholeSlots(t *testing.T) {
+	nine := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	hours := func(n int) time.Time { return nine.Add(time.Duration(n) * time.Hour) }
+
+	// The appointment was split into 2h slots and now into 1h ones
+	appointment := &models.Appointment{StartTime: nine, EndTime: hours(4), Duration: time.Hour}
+	valid := slotKeys(GenerateSlots(appointment))
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       bool
+	}{
+		{"new first slot", hours(0), hours(1), true},
+		{"new last slot", hours(3), hours(4), true},
+		{"old slot spanning two new ones", hours(0), hours(2), false},
+		{"old slot ending at the window end", hours(2), hours(4), false},
+		{"start of one slot and end of another", hours(1), hours(3), false},
+		{"same instants in another zone", hours(1).In(time.FixedZone("UTC+2", 2*3600)), hours(2), true},
+		{"outside the window", hours(4), hours(5), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := valid[newSlotKey(tt.start, tt.end)]; got != tt.want {
+				t.Errorf("booking %v to %v matches a slot = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlotKeysWithoutSlots(t *testing.T) {
+	// A window shorter than the duration has no slot, so every booking is stale
+	nine := time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC)
+	appointment := &models.Appointment{StartTime: nine, EndTime: nine.Add(30 * time.Minute), Duration: time.Hour}
+	if valid := slotKeys(GenerateSlots(appointment)); len(valid) != 0 {
+		t.Errorf("slotKeys = %v, want none", valid)
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/auth"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultRefreshTokenTTL is how long a refresh token stays valid when REFRESH_TOKEN_TTL is not set
+const defaultRefreshTokenTTL = 30 * 24 * time.Hour
+
+// refreshTokenStatus is what presenting a refresh token amounts to.
+type refreshTokenStatus int
+
+const (
+	// refreshTokenUsable may be exchanged for a new token
+	refreshTokenUsable refreshTokenStatus = iota
+	// refreshTokenReused was exchanged before, so it may have been stolen
+	refreshTokenReused
+	// refreshTokenInvalid was revoked or has expired
+	refreshTokenInvalid
+)
+
+// issueRefreshToken creates a refresh token of the session valid until expiresAt. Only its
+// hash is stored.
+func issueRefreshToken(tx *gorm.DB, userID, sessionID uuid.UUID, expiresAt time.Time) (string, *models.RefreshToken, error) {
+	token, err := utils.GenerateSecretToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	refreshToken := &models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  sessionID,
+		TokenHash: utils.HashSecretToken(token),
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(refreshToken).Error; err != nil {
+		return "", nil, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+	return token, refreshToken, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one of the same session and returns
+// the user it belongs to. A token that was already exchanged ends its whole session.
+func RotateRefreshToken(token string) (*models.SessionTokens, error) {
+	var user models.User
+	tokens := &models.SessionTokens{User: &user}
+	reused := false
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := lockRefreshToken(tx, token)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		switch checkRefreshToken(current, now) {
+		case refreshTokenReused:
+			// Keep the revocation; the caller still gets an error
+			reused = true
+			user.ID = current.UserID
+			return endSession(tx, current.FamilyID, now)
+		case refreshTokenInvalid:
+			return fmt.Errorf("invalid refresh token")
+		}
+		if err := tx.First(&user, "id = ?", current.UserID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("invalid refresh token")
+			}
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+
+		expiresAt := now.Add(durationEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL))
+		next, replacement, err := issueRefreshToken(tx, current.UserID, current.FamilyID, expiresAt)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(current).Updates(map[string]interface{}{
+			"used_at":     now,
+			"replaced_by": replacement.ID,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to rotate refresh token: %w", err)
+		}
+		result := tx.Model(&models.Session{}).Where("id = ? AND ended_at IS NULL", current.FamilyID).
+			Updates(map[string]interface{}{"expires_at": expiresAt, "last_seen_at": now})
+		if result.Error != nil {
+			return fmt.Errorf("failed to update session: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("invalid refresh token")
+		}
+
+		tokens.SessionID = current.FamilyID
+		tokens.RefreshToken = next
+		tokens.RefreshExpiresAt = expiresAt
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		log.Printf("Refresh token reused, ended its session for user %s", user.ID)
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+	return tokens, nil
+}
+
+// checkRefreshToken classifies a presented refresh token. Reuse is checked first, so a stolen
+// token still ends its session after it was revoked or expired.
+func checkRefreshToken(token *models.RefreshToken, now time.Time) refreshTokenStatus {
+	switch {
+	case token.UsedAt != nil:
+		return refreshTokenReused
+	case token.RevokedAt != nil || !now.Before(token.ExpiresAt):
+		return refreshTokenInvalid
+	}
+	return refreshTokenUsable
+}
+
+// RevokeRefreshToken ends the session of a refresh token. Unknown tokens are ignored.
+func RevokeRefreshToken(token string) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		current, err := lockRefreshToken(tx, token)
+		if err != nil {
+			if err.Error() == "invalid refresh token" {
+				return nil
+			}
+			return err
+		}
+		return endSession(tx, current.FamilyID, time.Now())
+	})
+}
+
+// lockRefreshToken loads and locks the refresh token, so concurrent exchanges of it are serialized.
+func lockRefreshToken(tx *gorm.DB, token string) (*models.RefreshToken, error) {
+	if token == "" {
+		return nil, fmt.Errorf("invalid refresh token")
+	}
+
+	var refreshToken models.RefreshToken
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ?", utils.HashSecretToken(token)).First(&refreshToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("invalid refresh token")
+		}
+		return nil, fmt.Errorf("failed to load refresh token: %w", err)
+	}
+	return &refreshToken, nil
+}
+
+// revokeTokenFamily revokes every token of the family that is not revoked yet.
+func revokeTokenFamily(tx *gorm.DB, familyID uuid.UUID, now time.Time) error {
+	if err := tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", now).Error; err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// CheckAccessToken rejects access tokens that were revoked, issued before the user's last
+// "log out everywhere", belong to a session that was ended, or belong to a user who no longer
+// exists. Accepted tokens mark their session as seen.
+func CheckAccessToken(claims *auth.Claims) error {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("token revoked")
+	}
+
+	var state accessTokenState
+	result := db.DB.Model(&models.User{}).
+		Select(`token_version, EXISTS (SELECT 1 FROM token_revocations WHERE jti = ?) AS revoked,
+			EXISTS (SELECT 1 FROM sessions WHERE sessions.id = ? AND sessions.user_id = users.id
+				AND sessions.ended_at IS NULL) AS session_active`, claims.Id, sessionID).
+		Where("id = ?", claims.Subject).Limit(1).Scan(&state)
+	if result.Error != nil {
+		return fmt.Errorf("failed to check token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("token revoked")
+	}
+	if err := acceptAccessToken(claims, &state); err != nil {
+		return err
+	}
+
+	touchSession(sessionID)
+	return nil
+}
+
+// accessTokenState is what the database holds about the user and session of an access token.
+type accessTokenState struct {
+	TokenVersion  int
+	Revoked       bool
+	SessionActive bool
+}
+
+// acceptAccessToken decides on an access token of an existing user from its state.
+func acceptAccessToken(claims *auth.Claims, state *accessTokenState) error {
+	if state.Revoked || !state.SessionActive || state.TokenVersion != claims.TokenVersion {
+		return fmt.Errorf("token revoked")
+	}
+	return nil
+}
+
+// RevokeAccessToken adds an access token to the revocation list until it expires and ends
+// the session it was issued for.
+func RevokeAccessToken(claims *auth.Claims) error {
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return fmt.Errorf("invalid token")
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return fmt.Errorf("invalid token")
+	}
+
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		revocation := &models.TokenRevocation{
+			JTI:       claims.Id,
+			UserID:    userID,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(revocation).Error; err != nil {
+			return fmt.Errorf("failed to revoke token: %w", err)
+		}
+		return endSession(tx, sessionID, time.Now())
+	})
+}
+
+// LogoutEverywhere invalidates every access and refresh token of the user by bumping their
+// token version and ending all their sessions.
+func LogoutEverywhere(userID uuid.UUID) error {
+	return db.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("user not found")
+			}
+			return fmt.Errorf("failed to load user: %w", err)
+		}
+
+		if err := tx.Model(&user).Update("token_version", gorm.Expr("token_version + 1")).Error; err != nil {
+			return fmt.Errorf("failed to revoke tokens: %w", err)
+		}
+		if err := endUserSessions(tx, userID, time.Now()); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, &user, models.EventUserUpdated, "sessions")
+	})
+}
+
+// PruneTokenRevocations drops revocations of tokens that have expired by now and returns how many were dropped.
+func PruneTokenRevocations() (int64, error) {
+	result := db.DB.Where("expires_at < ?", time.Now()).Delete(&models.TokenRevocation{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to prune token revocations: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/auth"
+	models "github.com/m13ha/appointment_master/models"
+)
+
+func TestCheckRefreshToken(t *testing.T) {
+	now := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+
+	tests := []struct {
+		name  string
+		token models.RefreshToken
+		want  refreshTokenStatus
+	}{
+		{"fresh", models.RefreshToken{ExpiresAt: now.Add(time.Hour)}, refreshTokenUsable},
+		{"expired", models.RefreshToken{ExpiresAt: now.Add(-time.Hour)}, refreshTokenInvalid},
+		{"expiring right now", models.RefreshToken{ExpiresAt: now}, refreshTokenInvalid},
+		{"revoked", models.RefreshToken{ExpiresAt: now.Add(time.Hour), RevokedAt: at(-time.Minute)}, refreshTokenInvalid},
+		{"exchanged before", models.RefreshToken{ExpiresAt: now.Add(time.Hour), UsedAt: at(-time.Minute)}, refreshTokenReused},
+		// A replayed token is still treated as reuse once its family was revoked or it expired
+		{"exchanged and revoked", models.RefreshToken{ExpiresAt: now.Add(time.Hour), UsedAt: at(-time.Hour), RevokedAt: at(-time.Minute)}, refreshTokenReused},
+		{"exchanged and expired", models.RefreshToken{ExpiresAt: now.Add(-time.Minute), UsedAt: at(-time.Hour)}, refreshTokenReused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkRefreshToken(&tt.token, now); got != tt.want {
+				t.Errorf("checkRefreshToken = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptAccessToken(t *testing.T) {
+	claims := &auth.Claims{TokenVersion: 3, SessionID: uuid.NewString()}
+
+	tests := []struct {
+		name  string
+		state accessTokenState
+		valid bool
+	}{
+		{"current", accessTokenState{TokenVersion: 3, SessionActive: true}, true},
+		{"revoked by logout", accessTokenState{TokenVersion: 3, Revoked: true, SessionActive: true}, false},
+		{"issued before logging out everywhere", accessTokenState{TokenVersion: 4, SessionActive: true}, false},
+		{"newer than the user", accessTokenState{TokenVersion: 2, SessionActive: true}, false},
+		{"session ended", accessTokenState{TokenVersion: 3}, false},
+		{"everything revoked", accessTokenState{TokenVersion: 4, Revoked: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := acceptAccessToken(claims, &tt.state)
+			if tt.valid && err != nil {
+				t.Errorf("acceptAccessToken failed: %v", err)
+			}
+			if !tt.valid && (err == nil || err.Error() != "token revoked") {
+				t.Errorf("acceptAccessToken error = %v, want token revoked", err)
+			}
+		})
+	}
+}
+
+func TestCheckAccessTokenRejectsMalformedSessions(t *testing.T) {
+	// Tokens without a valid session are refused before the database is asked
+	for _, sessionID := range []string{"", "not-a-session"} {
+		claims := &auth.Claims{
+			SessionID:      sessionID,
+			StandardClaims: jwt.StandardClaims{Id: uuid.NewString(), Subject: uuid.NewString()},
+		}
+		if err := CheckAccessToken(claims); err == nil || err.Error() != "token revoked" {
+			t.Errorf("CheckAccessToken with session %q error = %v, want token revoked", sessionID, err)
+		}
+	}
+}
+
+func TestRevokeAccessTokenRejectsMalformedClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims auth.Claims
+	}{
+		{"bad subject", auth.Claims{SessionID: uuid.NewString(), StandardClaims: jwt.StandardClaims{Subject: "someone"}}},
+		{"bad session", auth.Claims{SessionID: "none", StandardClaims: jwt.StandardClaims{Subject: uuid.NewString()}}},
+	}
+	for _, tt := range tests {
+		if err := RevokeAccessToken(&tt.claims); err == nil || err.Error() != "invalid token" {
+			t.Errorf("RevokeAccessToken with %s error = %v, want invalid token", tt.name, err)
+		}
+	}
+}
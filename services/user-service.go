@@ -1,13 +1,32 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
 	"github.com/m13ha/appointment_master/db"
 	"github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // CreateUser creates a new user and saves it to the database.
 func CreateUser(userReq models.UserRequest) (*models.User, error) {
+	if userReq.TimeZone == "" {
+		userReq.TimeZone = "UTC"
+	}
+	if _, err := utils.LoadTimeZone(userReq.TimeZone); err != nil {
+		return nil, fmt.Errorf("invalid time zone")
+	}
+	if userReq.NotifyVia == "" {
+		userReq.NotifyVia = models.NotifyEmail
+	}
+	if err := validateNotifyVia(userReq.NotifyVia, userReq.Phone); err != nil {
+		return nil, err
+	}
+
 	// Hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userReq.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -18,20 +37,122 @@ func CreateUser(userReq models.UserRequest) (*models.User, error) {
 		Name:           userReq.Name,
 		Email:          userReq.Email,
 		HashedPassword: string(hashedPassword),
+		TimeZone:       userReq.TimeZone,
+		Phone:          userReq.Phone,
+		NotifyVia:      userReq.NotifyVia,
 	}
 
-	if err := db.DB.Create(user).Error; err != nil {
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return recordUserEvent(tx, user, models.EventUserCreated)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
 
+// AuthenticateUser returns the user with the given email when the password matches.
+func AuthenticateUser(email, password string) (*models.User, error) {
+	var user models.User
+	if err := db.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("invalid email or password")
+		}
+		return nil, err
+	}
+	if !user.CheckPassword(password) {
+		return nil, fmt.Errorf("invalid email or password")
+	}
+	return &user, nil
+}
+
 // GetRegisteredAppointments retrieves appointments registered by a user.
 func GetRegisteredAppointments(userID string) ([]models.Appointment, error) {
 	var appointments []models.Appointment
-	if err := db.DB.Where("user_id = ?", userID).Find(&appointments).Error; err != nil {
+	if err := db.DB.Distinct("appointments.*").
+		Joins("JOIN bookings ON bookings.appointment_id = appointments.id AND bookings.deleted_at IS NULL").
+		Where("bookings.user_id = ?", userID).
+		Find(&appointments).Error; err != nil {
 		return nil, err
 	}
 	return appointments, nil
 }
+
+// GetUser retrieves a user by ID.
+func GetUser(userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := db.DB.First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateUser applies the given settings to a user.
+func UpdateUser(userID uuid.UUID, req models.UserUpdateRequest) (*models.User, error) {
+	user, err := GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	if req.Name != nil && *req.Name != user.Name {
+		if *req.Name == "" {
+			return nil, fmt.Errorf("name is required")
+		}
+		user.Name = *req.Name
+		changed = append(changed, "name")
+	}
+	if req.TimeZone != nil && *req.TimeZone != user.TimeZone {
+		if _, err := utils.LoadTimeZone(*req.TimeZone); err != nil || *req.TimeZone == "" {
+			return nil, fmt.Errorf("invalid time zone")
+		}
+		user.TimeZone = *req.TimeZone
+		changed = append(changed, "time_zone")
+	}
+	if req.Phone != nil && *req.Phone != user.Phone {
+		user.Phone = *req.Phone
+		changed = append(changed, "phone")
+	}
+	if req.NotifyVia != nil && *req.NotifyVia != user.NotifyVia {
+		user.NotifyVia = *req.NotifyVia
+		changed = append(changed, "notify_via")
+	}
+	if err := validateNotifyVia(user.NotifyVia, user.Phone); err != nil {
+		return nil, err
+	}
+	if len(changed) == 0 {
+		return user, nil
+	}
+
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		return recordUserEvent(tx, user, models.EventUserUpdated, changed...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// validateNotifyVia checks a notification preference; text messages need a phone number.
+func validateNotifyVia(notifyVia, phone string) error {
+	switch notifyVia {
+	case models.NotifyEmail, models.NotifyNone:
+	case models.NotifySMS:
+		if phone == "" {
+			return fmt.Errorf("phone is required for sms notifications")
+		}
+	default:
+		return fmt.Errorf("invalid notification preference")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"gorm.io/gorm"
+)
+
+// JoinWaitlist adds the user to the waitlist of a full appointment slot.
+func JoinWaitlist(req models.WaitlistRequest) (*models.WaitlistEntry, error) {
+	var entry *models.WaitlistEntry
+	err := db.DB.Transaction(func(tx *gorm.DB) error {
+		appointment, err := lockAppointment(tx, req.AppointmentID.String())
+		if err != nil {
+			return err
+		}
+
+		slots, err := slotsWithStatus(tx, appointment)
+		if err != nil {
+			return err
+		}
+		slot, ok := findSlot(slots, req.StartTime, req.EndTime)
+		if !ok {
+			return fmt.Errorf("booking must match an appointment slot")
+		}
+		if err := checkBookingWindow(appointment, slot.StartTime, time.Now()); err != nil {
+			return err
+		}
+		if slot.Status == models.SlotFree {
+			return fmt.Errorf("slot is not full")
+		}
+
+		// A user waits at most once per slot
+		var count int64
+		if err := tx.Model(&models.WaitlistEntry{}).
+			Where("appointment_id = ? AND user_id = ? AND start_time = ? AND promoted_at IS NULL",
+				appointment.ID, req.UserID, req.StartTime).
+			Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to check waitlist: %w", err)
+		}
+		if count > 0 {
+			return fmt.Errorf("already on the waitlist")
+		}
+
+		entry = &models.WaitlistEntry{
+			UserID:        req.UserID,
+			AppointmentID: appointment.ID,
+			StartTime:     slot.StartTime,
+			EndTime:       slot.EndTime,
+		}
+		if err := tx.Create(entry).Error; err != nil {
+			return fmt.Errorf("failed to join waitlist: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// GetWaitlist retrieves the waiting entries of an appointment in join order. The organizer
+// sees every entry, other users only see their own.
+func GetWaitlist(appointmentID string, userID uuid.UUID) ([]models.WaitlistEntry, error) {
+	var appointment models.Appointment
+	if err := db.DB.First(&appointment, "id = ?", appointmentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("appointment not found")
+		}
+		return nil, err
+	}
+
+	query := db.DB.Where("appointment_id = ? AND promoted_at IS NULL", appointment.ID)
+	if appointment.UserID != userID {
+		query = query.Where("user_id = ?", userID)
+	}
+
+	var entries []models.WaitlistEntry
+	if err := query.Order("created_at").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// WaitlistPosition returns the 1-based position of an entry among those waiting for the same slot.
+func WaitlistPosition(entry *models.WaitlistEntry) (int, error) {
+	var ahead int64
+	if err := db.DB.Model(&models.WaitlistEntry{}).
+		Where("appointment_id = ? AND start_time = ? AND promoted_at IS NULL AND created_at < ?",
+			entry.AppointmentID, entry.StartTime, entry.CreatedAt).
+		Count(&ahead).Error; err != nil {
+		return 0, err
+	}
+	return int(ahead) + 1, nil
+}
+
+// LeaveWaitlist removes a waiting entry. Only the waiting user or the organizer may remove it.
+func LeaveWaitlist(appointmentID, entryID string, userID uuid.UUID) error {
+	var entry models.WaitlistEntry
+	if err := db.DB.Preload("Appointment").
+		Where("id = ? AND appointment_id = ? AND promoted_at IS NULL", entryID, appointmentID).
+		First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("waitlist entry not found")
+		}
+		return fmt.Errorf("failed to load waitlist entry: %w", err)
+	}
+
+	if entry.UserID != userID && entry.Appointment.UserID != userID {
+		return fmt.Errorf("not allowed to remove this waitlist entry")
+	}
+
+	if err := db.DB.Delete(&entry).Error; err != nil {
+		return fmt.Errorf("failed to leave waitlist: %w", err)
+	}
+	return nil
+}
+
+// promoteWaitlist books waiting users, oldest first, into places that became free.
+// Entries whose user now holds an overlapping booking keep waiting. The caller must
+// hold the appointment lock.
+func promoteWaitlist(tx *gorm.DB, appointment *models.Appointment) error {
+	var entries []models.WaitlistEntry
+	if err := tx.Where("appointment_id = ? AND promoted_at IS NULL", appointment.ID).
+		Order("created_at").Find(&entries).Error; err != nil {
+		return fmt.Errorf("failed to load waitlist: %w", err)
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		booking, err := bookSlot(tx, appointment, entry.UserID, entry.StartTime, entry.EndTime, nil)
+		if err != nil {
+			switch err.Error() {
+			case "slot is full", "appointment is full", "overlapping booking exists", "booking must match an appointment slot",
+				"outside organizer availability", "booking notice is too short", "booking is too far in advance":
+				continue
+			}
+			return err
+		}
+
+		now := time.Now()
+		entry.BookingID = &booking.ID
+		entry.PromotedAt = &now
+		if err := tx.Save(entry).Error; err != nil {
+			return fmt.Errorf("failed to record waitlist promotion: %w", err)
+		}
+		log.Printf("Promoted waitlist entry %s to booking %s", entry.ID, booking.ID)
+	}
+	return nil
+}
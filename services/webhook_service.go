@@ -0,0 +1,497 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m13ha/appointment_master/db"
+	models "github.com/m13ha/appointment_master/models"
+	"github.com/m13ha/appointment_master/utils"
+	"gorm.io/gorm"
+)
+
+// JobDeliverWebhook posts one webhook delivery to its endpoint
+const JobDeliverWebhook = "deliver_webhook"
+
+const (
+	// webhookMaxAttempts bounds the retries of a delivery, about an hour with the job backoff
+	webhookMaxAttempts = 8
+	// webhookDeliveryLogSize is how many deliveries of an endpoint are listed
+	webhookDeliveryLogSize = 100
+)
+
+// webhookEvents lists the events endpoints may subscribe to
+var webhookEvents = []string{
+	models.EventAppointmentCreated,
+	models.EventAppointmentUpdated,
+	models.EventAppointmentCancelled,
+	models.EventBookingCreated,
+	models.EventBookingUpdated,
+	models.EventBookingCancelled,
+	models.EventUserUpdated,
+}
+
+// webhookClient posts deliveries; receivers must answer within its timeout. It only connects
+// to public addresses, checked after DNS resolution so rebinding a name cannot get around it,
+// and does not follow redirects.
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+					return fmt.Errorf("webhook address %s is not public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+		MaxIdleConnsPerHost: 2,
+	},
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// webhookJob is the payload of a delivery job.
+type webhookJob struct {
+	DeliveryID uuid.UUID `json:"delivery_id"`
+}
+
+// CreateWebhookEndpoint registers a webhook endpoint for the user with a fresh signing secret.
+func CreateWebhookEndpoint(userID uuid.UUID, req models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	if req.URL == nil {
+		return nil, fmt.Errorf("invalid webhook url")
+	}
+	if req.Events == nil {
+		return nil, fmt.Errorf("at least one event is required")
+	}
+	endpoint := &models.WebhookEndpoint{UserID: userID, Active: true}
+	if err := applyWebhookRequest(endpoint, req); err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateSecretToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	endpoint.Secret = secret
+
+	if err := db.DB.Create(endpoint).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return endpoint, nil
+}
+
+// GetWebhookEndpoints lists the webhook endpoints of the user.
+func GetWebhookEndpoints(userID uuid.UUID) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := db.DB.Where("user_id = ?", userID).Order("created_at").Find(&endpoints).Error; err != nil {
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// UpdateWebhookEndpoint changes the URL, events or active flag of an endpoint owned by the user.
+func UpdateWebhookEndpoint(endpointID string, userID uuid.UUID, req models.WebhookEndpointRequest) (*models.WebhookEndpoint, error) {
+	endpoint, err := ownedWebhookEndpoint(endpointID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyWebhookRequest(endpoint, req); err != nil {
+		return nil, err
+	}
+
+	if err := db.DB.Save(endpoint).Error; err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return endpoint, nil
+}
+
+// RotateWebhookSecret replaces the signing secret of an endpoint owned by the user.
+func RotateWebhookSecret(endpointID string, userID uuid.UUID) (*models.WebhookEndpoint, error) {
+	endpoint, err := ownedWebhookEndpoint(endpointID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := utils.GenerateSecretToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	if err := db.DB.Model(endpoint).Update("secret", secret).Error; err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	endpoint.Secret = secret
+	return endpoint, nil
+}
+
+// DeleteWebhookEndpoint removes an endpoint owned by the user. Queued deliveries to it are dropped.
+func DeleteWebhookEndpoint(endpointID string, userID uuid.UUID) error {
+	endpoint, err := ownedWebhookEndpoint(endpointID, userID)
+	if err != nil {
+		return err
+	}
+	if err := db.DB.Delete(endpoint).Error; err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists the latest deliveries to an endpoint owned by the user, newest first.
+func GetWebhookDeliveries(endpointID string, userID uuid.UUID) ([]models.WebhookDelivery, error) {
+	endpoint, err := ownedWebhookEndpoint(endpointID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := db.DB.Where("endpoint_id = ?", endpoint.ID).Order("created_at DESC").
+		Limit(webhookDeliveryLogSize).Find(&deliveries).Error; err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RedeliverWebhook sends the event of a past delivery to its endpoint again as a new delivery.
+func RedeliverWebhook(endpointID, deliveryID string, userID uuid.UUID) (*models.WebhookDelivery, error) {
+	endpoint, err := ownedWebhookEndpoint(endpointID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !endpoint.Active {
+		return nil, fmt.Errorf("webhook is disabled")
+	}
+
+	var original models.WebhookDelivery
+	if err := db.DB.Where("id = ? AND endpoint_id = ?", deliveryID, endpoint.ID).First(&original).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("delivery not found")
+		}
+		return nil, err
+	}
+
+	delivery := &models.WebhookDelivery{
+		EndpointID:   endpoint.ID,
+		EventID:      original.EventID,
+		Event:        original.Event,
+		Payload:      original.Payload,
+		Status:       models.DeliveryPending,
+		RedeliveryOf: &original.ID,
+	}
+	err = db.DB.Transaction(func(tx *gorm.DB) error {
+		return createWebhookDelivery(tx, delivery)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// ownedWebhookEndpoint loads an endpoint of the user. Endpoints of other users are reported as missing.
+func ownedWebhookEndpoint(endpointID string, userID uuid.UUID) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := db.DB.Where("id = ? AND user_id = ?", endpointID, userID).First(&endpoint).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("webhook not found")
+		}
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// applyWebhookRequest copies the set fields of the request onto the endpoint after validating them.
+func applyWebhookRequest(endpoint *models.WebhookEndpoint, req models.WebhookEndpointRequest) error {
+	if req.URL != nil {
+		parsed, err := url.Parse(*req.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+			return fmt.Errorf("invalid webhook url")
+		}
+		// Reject obviously internal targets early; the client checks every address it dials
+		if ip := net.ParseIP(parsed.Hostname()); (ip != nil && !isPublicIP(ip)) || strings.EqualFold(parsed.Hostname(), "localhost") {
+			return fmt.Errorf("invalid webhook url")
+		}
+		endpoint.URL = parsed.String()
+	}
+
+	if req.Events != nil {
+		if len(req.Events) == 0 {
+			return fmt.Errorf("at least one event is required")
+		}
+		seen := make(map[string]bool)
+		var events []string
+		for _, event := range req.Events {
+			if !isWebhookEvent(event) {
+				return fmt.Errorf("invalid webhook event")
+			}
+			if !seen[event] {
+				seen[event] = true
+				events = append(events, event)
+			}
+		}
+		endpoint.Events = strings.Join(events, ",")
+	}
+
+	if req.Active != nil {
+		endpoint.Active = *req.Active
+	}
+	return nil
+}
+
+// nonPublicNetworks are IPv4 ranges refused besides the ones the net.IP methods recognize:
+// "this network", carrier-grade NAT shared space, IETF protocol assignments, benchmarking
+// and the reserved range up to the broadcast address.
+var nonPublicNetworks = mustParseCIDRs("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4")
+
+// isPublicIP reports whether webhooks may be sent to the address: loopback, private,
+// link-local (which includes cloud metadata services) and similar addresses are refused.
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range nonPublicNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// mustParseCIDRs parses fixed network ranges.
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// isWebhookEvent reports whether endpoints may subscribe to the event.
+func isWebhookEvent(event string) bool {
+	for _, known := range webhookEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookEvents returns the subscribed events of an endpoint.
+func WebhookEvents(endpoint *models.WebhookEndpoint) []string {
+	if endpoint.Events == "" {
+		return []string{}
+	}
+	return strings.Split(endpoint.Events, ",")
+}
+
+// subscribesTo reports whether the endpoint wants the event.
+func subscribesTo(endpoint *models.WebhookEndpoint, event string) bool {
+	for _, subscribed := range WebhookEvents(endpoint) {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// publishWebhooks is the outbox consumer delivering domain events to the webhook endpoints of
+// the users involved: the organizer for appointments, the organizer and the participant for
+// bookings, and the user themselves for profile changes.
+func publishWebhooks(tx *gorm.DB, event *models.OutboxEvent) error {
+	if !isWebhookEvent(event.Event) {
+		return nil
+	}
+
+	var userIDs []uuid.UUID
+	switch event.AggregateType {
+	case models.AggregateAppointment:
+		var data models.AppointmentEventData
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return fmt.Errorf("invalid event payload: %w", err)
+		}
+		userIDs = []uuid.UUID{data.UserID}
+	case models.AggregateBooking:
+		var data models.BookingEventData
+		if err := json.Unmarshal([]byte(event.Payload), &data); err != nil {
+			return fmt.Errorf("invalid event payload: %w", err)
+		}
+		var appointment models.Appointment
+		if err := tx.Unscoped().Select("id", "user_id").First(&appointment, "id = ?", data.AppointmentID).Error; err != nil {
+			return fmt.Errorf("failed to load appointment: %w", err)
+		}
+		userIDs = uniqueIDs([]uuid.UUID{appointment.UserID, data.UserID})
+	case models.AggregateUser:
+		userIDs = []uuid.UUID{event.AggregateID}
+	}
+
+	return queueWebhooks(tx, userIDs, event)
+}
+
+// queueWebhooks records a delivery of the event to every active endpoint of the users
+// subscribed to it. The event ID lets receivers recognise repeated deliveries.
+func queueWebhooks(tx *gorm.DB, userIDs []uuid.UUID, event *models.OutboxEvent) error {
+	var endpoints []models.WebhookEndpoint
+	if err := tx.Where("user_id IN ? AND active", userIDs).Find(&endpoints).Error; err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+
+	var payload []byte
+	for i := range endpoints {
+		if !subscribesTo(&endpoints[i], event.Event) {
+			continue
+		}
+		if payload == nil {
+			var err error
+			payload, err = json.Marshal(models.WebhookPayload{
+				ID:        event.ID,
+				Event:     event.Event,
+				CreatedAt: event.CreatedAt.UTC(),
+				Data:      json.RawMessage(event.Payload),
+			})
+			if err != nil {
+				return fmt.Errorf("failed to encode webhook payload: %w", err)
+			}
+		}
+
+		delivery := &models.WebhookDelivery{
+			EndpointID: endpoints[i].ID,
+			EventID:    event.ID,
+			Event:      event.Event,
+			Payload:    string(payload),
+			Status:     models.DeliveryPending,
+		}
+		if err := createWebhookDelivery(tx, delivery); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createWebhookDelivery saves a delivery and queues the job sending it.
+func createWebhookDelivery(tx *gorm.DB, delivery *models.WebhookDelivery) error {
+	if err := tx.Create(delivery).Error; err != nil {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+	return enqueueJobAttempts(tx, JobDeliverWebhook, "", webhookJob{DeliveryID: delivery.ID}, time.Now(), webhookMaxAttempts)
+}
+
+// deliverWebhook is the handler of delivery jobs. It posts the signed payload and records the
+// outcome; an error makes the scheduler retry unless this was the final attempt.
+func deliverWebhook(payload string, final bool) error {
+	var job webhookJob
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		return fmt.Errorf("invalid webhook payload: %w", err)
+	}
+
+	var delivery models.WebhookDelivery
+	if err := db.DB.First(&delivery, "id = ?", job.DeliveryID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if delivery.Status == models.DeliveryDelivered {
+		return nil
+	}
+
+	var endpoint models.WebhookEndpoint
+	if err := db.DB.First(&endpoint, "id = ?", delivery.EndpointID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return recordWebhookAttempt(&delivery, 0, fmt.Errorf("webhook was deleted"), true)
+		}
+		return err
+	}
+	if !endpoint.Active {
+		return recordWebhookAttempt(&delivery, 0, fmt.Errorf("webhook is disabled"), true)
+	}
+
+	status, err := postWebhook(&endpoint, &delivery)
+	if err == nil && (status < 200 || status > 299) {
+		err = fmt.Errorf("endpoint answered with status %d", status)
+	}
+	if recordErr := recordWebhookAttempt(&delivery, status, err, final); recordErr != nil {
+		return recordErr
+	}
+	return err
+}
+
+// postWebhook sends a delivery and returns the status of the response. The response body is
+// discarded so the delivery log cannot be used to read what an endpoint returns.
+func postWebhook(endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader([]byte(delivery.Payload)))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "appointment-master-webhooks")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", delivery.ID.String())
+	req.Header.Set("X-Webhook-Signature", SignWebhook(endpoint.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the X-Webhook-Signature header for a payload sent at the given Unix time:
+// "t=<timestamp>,v1=<signature>", where the signature is the hex HMAC-SHA256 of "<timestamp>.<payload>"
+// keyed with the endpoint secret. Receivers should reject timestamps too far from their clock.
+func SignWebhook(secret string, timestamp int64, payload []byte) string {
+	ts := strconv.FormatInt(timestamp, 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// recordWebhookAttempt stores the outcome of a delivery attempt in the delivery log.
+func recordWebhookAttempt(delivery *models.WebhookDelivery, status int, deliveryErr error, final bool) error {
+	updates := map[string]interface{}{
+		"attempts":        gorm.Expr("attempts + 1"),
+		"response_status": status,
+		"last_error":      "",
+	}
+	switch {
+	case deliveryErr == nil:
+		updates["status"] = models.DeliveryDelivered
+		updates["delivered_at"] = time.Now()
+	case final:
+		updates["status"] = models.DeliveryFailed
+		updates["last_error"] = deliveryErr.Error()
+	default:
+		updates["status"] = models.DeliveryRetrying
+		updates["last_error"] = deliveryErr.Error()
+	}
+
+	if err := db.DB.Model(delivery).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	models "github.com/m13ha/appointment_master/models"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"2606:4700:4700::1111", true},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"10.0.0.1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"172.16.0.1", false},
+		{"192.0.0.8", false},
+		{"192.168.1.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.64.0.1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+	}
+	for _, tt := range tests {
+		if got := isPublicIP(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	payload := []byte(`{"event":"booking.created"}`)
+	tests := []struct {
+		secret    string
+		timestamp int64
+		payload   []byte
+		want      string
+	}{
+		{"whsec_test", 1700000000, payload, "t=1700000000,v1=612003e72749f743f6381c0dbfd68c80a4636ee4e67201b170529734fb032ab2"},
+		{"whsec_test", 1700000001, payload, "t=1700000001,v1=5c5b9c439c6e8ca827adfba9134f174a57e140a5285c0f47af8f75ceb7751c0d"},
+		{"", 0, nil, "t=0,v1=b849d5a581847b281957065739df36df2463d1977ea8d6e1e4e6cf33fadc68c3"},
+	}
+	for _, tt := range tests {
+		if got := SignWebhook(tt.secret, tt.timestamp, tt.payload); got != tt.want {
+			t.Errorf("SignWebhook(%q, %d) = %q, want %q", tt.secret, tt.timestamp, got, tt.want)
+		}
+	}
+	if SignWebhook("other", 1700000000, payload) == tests[0].want {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestApplyWebhookRequestURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://hooks.example.com/receive", true},
+		{"http://93.184.216.34:8080/hook", true},
+		{"ftp://hooks.example.com/receive", false},
+		{"https:///no-host", false},
+		{"http://localhost:8080/hook", false},
+		{"http://127.0.0.1/hook", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"http://100.64.0.1/hook", false},
+		{"http://[::1]/hook", false},
+		{"http://0.0.0.0/hook", false},
+	}
+	for _, tt := range tests {
+		url := tt.url
+		err := applyWebhookRequest(&models.WebhookEndpoint{}, models.WebhookEndpointRequest{URL: &url})
+		if (err == nil) != tt.valid {
+			t.Errorf("applyWebhookRequest(%q) error = %v, want valid %v", tt.url, err, tt.valid)
+		}
+	}
+}
+
+func TestWebhookClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook client reached a loopback server")
+	}))
+	defer server.Close()
+
+	_, err := webhookClient.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "is not public") {
+		t.Fatalf("request to %s error = %v, want it refused", server.URL, err)
+	}
+}
@@ -0,0 +1,138 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	icalUTCLayout   = "20060102T150405Z"
+	icalLocalLayout = "20060102T150405"
+	icalDateLayout  = "20060102"
+	// icalProductID identifies this application in generated calendars
+	icalProductID = "-//appointment_master//Appointment Master//EN"
+)
+
+// ParseICalTime parses an iCalendar DATE or DATE-TIME value. UTC values end in "Z";
+// floating date-times and dates are read in loc.
+func ParseICalTime(value string, loc *time.Location) (time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse(icalUTCLayout, value)
+	case len(value) == len(icalDateLayout):
+		return time.ParseInLocation(icalDateLayout, value, loc)
+	case len(value) == len(icalLocalLayout):
+		return time.ParseInLocation(icalLocalLayout, value, loc)
+	}
+	return time.Time{}, fmt.Errorf("invalid iCalendar time %q", value)
+}
+
+// FormatICalTime formats an instant as an iCalendar UTC DATE-TIME value.
+func FormatICalTime(t time.Time) string {
+	return t.UTC().Format(icalUTCLayout)
+}
+
+// ICalPerson is an organizer or attendee of a calendar event.
+type ICalPerson struct {
+	Name  string
+	Email string
+}
+
+// ICalEvent is a VEVENT of a generated calendar.
+type ICalEvent struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	Stamp       time.Time // When the event data last changed
+	Sequence    int
+	Status      string // TENTATIVE, CONFIRMED or CANCELLED; empty omits it
+	Organizer   *ICalPerson
+	Attendees   []ICalPerson
+}
+
+// BuildICalendar renders the events as a VCALENDAR document with CRLF line endings
+// and folded lines, as required by RFC 5545.
+func BuildICalendar(name string, events []ICalEvent) string {
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN:VCALENDAR")
+	writeICalLine(&b, "VERSION:2.0")
+	writeICalLine(&b, "PRODID:"+icalProductID)
+	writeICalLine(&b, "CALSCALE:GREGORIAN")
+	writeICalLine(&b, "METHOD:PUBLISH")
+	if name != "" {
+		writeICalLine(&b, "X-WR-CALNAME:"+EscapeICalText(name))
+	}
+
+	for _, event := range events {
+		writeICalLine(&b, "BEGIN:VEVENT")
+		writeICalLine(&b, "UID:"+event.UID)
+		writeICalLine(&b, "DTSTAMP:"+FormatICalTime(event.Stamp))
+		writeICalLine(&b, "DTSTART:"+FormatICalTime(event.Start))
+		writeICalLine(&b, "DTEND:"+FormatICalTime(event.End))
+		writeICalLine(&b, fmt.Sprintf("SEQUENCE:%d", event.Sequence))
+		writeICalLine(&b, "SUMMARY:"+EscapeICalText(event.Summary))
+		if event.Description != "" {
+			writeICalLine(&b, "DESCRIPTION:"+EscapeICalText(event.Description))
+		}
+		if event.Status != "" {
+			writeICalLine(&b, "STATUS:"+event.Status)
+		}
+		if event.Organizer != nil {
+			writeICalLine(&b, "ORGANIZER"+icalPersonValue(*event.Organizer))
+		}
+		for _, attendee := range event.Attendees {
+			writeICalLine(&b, "ATTENDEE;ROLE=REQ-PARTICIPANT"+icalPersonValue(attendee))
+		}
+		writeICalLine(&b, "END:VEVENT")
+	}
+
+	writeICalLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// EscapeICalText escapes a TEXT property value.
+func EscapeICalText(value string) string {
+	return strings.NewReplacer(
+		`\`, `\\`,
+		";", `\;`,
+		",", `\,`,
+		"\r\n", `\n`,
+		"\n", `\n`,
+	).Replace(value)
+}
+
+// icalPersonValue formats the parameters and mailto value of an organizer or attendee.
+func icalPersonValue(person ICalPerson) string {
+	value := ""
+	if person.Name != "" {
+		value += `;CN="` + strings.ReplaceAll(person.Name, `"`, "'") + `"`
+	}
+	return value + ":mailto:" + person.Email
+}
+
+// writeICalLine writes a content line, folding it into 75 octet chunks without
+// splitting UTF-8 sequences.
+func writeICalLine(b *strings.Builder, line string) {
+	// Continuation lines start with a space, which counts toward their limit
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,496 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParsedICalEvent is a VEVENT read from an iCalendar document, with its times resolved.
+// An event that could not be read has Err set and only the properties read without error.
+type ParsedICalEvent struct {
+	UID     string
+	Summary string
+	Status  string
+	Start   time.Time
+	End     time.Time
+	AllDay  bool
+	RRule   string
+	ExDates []time.Time
+	Err     error
+}
+
+// icalProperty is a single unfolded content line.
+type icalProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icalComponent is a BEGIN/END block with its properties and nested components.
+type icalComponent struct {
+	Name       string
+	Properties []icalProperty
+	Children   []*icalComponent
+	err        error // First malformed content line of the component, which is skipped
+}
+
+// ParseICalendar reads the events of a VCALENDAR document. Times with a TZID are resolved
+// through the IANA zone database when the TZID names a known zone, and otherwise through
+// the VTIMEZONE definitions of the document. Only a document that cannot be read at all is
+// an error; a malformed event is returned with Err set, and a malformed VTIMEZONE fails the
+// events using it.
+func ParseICalendar(data string) ([]ParsedICalEvent, error) {
+	root, err := parseICalComponents(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []ParsedICalEvent
+	for _, calendar := range root.Children {
+		if calendar.Name != "VCALENDAR" {
+			continue
+		}
+
+		zones := make(map[string]*icalTimeZone)
+		for _, child := range calendar.Children {
+			if child.Name == "VTIMEZONE" {
+				zone, err := parseICalTimeZone(child)
+				if zone.id == "" {
+					continue
+				}
+				zone.err = err
+				zones[zone.id] = zone
+			}
+		}
+
+		for _, child := range calendar.Children {
+			if child.Name != "VEVENT" {
+				continue
+			}
+			event, err := parseICalEvent(child, zones)
+			event.Err = err
+			events = append(events, event)
+		}
+	}
+
+	if len(events) == 0 && len(root.Children) == 0 {
+		return nil, fmt.Errorf("no VCALENDAR found")
+	}
+	return events, nil
+}
+
+// parseICalComponents unfolds the content lines and builds the component tree. A malformed
+// line inside a component is recorded on the component, so only that component fails.
+func parseICalComponents(data string) (*icalComponent, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var lines []string
+	for _, line := range strings.Split(data, "\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	root := &icalComponent{}
+	stack := []*icalComponent{root}
+	for number, line := range lines {
+		current := stack[len(stack)-1]
+		property, err := parseICalProperty(line)
+		if err != nil {
+			if len(stack) == 1 {
+				return nil, fmt.Errorf("line %d: %v", number+1, err)
+			}
+			if current.err == nil {
+				current.err = fmt.Errorf("line %d: %v", number+1, err)
+			}
+			continue
+		}
+
+		switch property.Name {
+		case "BEGIN":
+			child := &icalComponent{Name: strings.ToUpper(property.Value)}
+			current.Children = append(current.Children, child)
+			stack = append(stack, child)
+		case "END":
+			if len(stack) == 1 || current.Name != strings.ToUpper(property.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", number+1, property.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			current.Properties = append(current.Properties, property)
+		}
+	}
+	if len(stack) != 1 {
+		return nil, fmt.Errorf("unterminated %s component", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// parseICalProperty splits a content line into its name, parameters and value.
+func parseICalProperty(line string) (icalProperty, error) {
+	// The value starts at the first colon outside a quoted parameter value
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return icalProperty{}, fmt.Errorf("invalid content line %q", line)
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	property := icalProperty{
+		Name:   strings.ToUpper(parts[0]),
+		Params: make(map[string]string),
+		Value:  line[colon+1:],
+	}
+	for _, param := range parts[1:] {
+		if key, value, ok := strings.Cut(param, "="); ok {
+			property.Params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+		}
+	}
+	return property, nil
+}
+
+// parseICalEvent resolves the properties of a VEVENT. It reads every property even after one
+// fails, so the event can still be identified, and returns the first error.
+func parseICalEvent(component *icalComponent, zones map[string]*icalTimeZone) (ParsedICalEvent, error) {
+	var event ParsedICalEvent
+	var duration time.Duration
+	hasEnd, hasDuration := false, false
+	var invalidName string
+	invalidErr := component.err
+
+	for _, property := range component.Properties {
+		var err error
+		switch property.Name {
+		case "UID":
+			event.UID = property.Value
+		case "SUMMARY":
+			event.Summary = UnescapeICalText(property.Value)
+		case "STATUS":
+			event.Status = strings.ToUpper(property.Value)
+		case "DTSTART":
+			event.Start, err = resolveICalTime(property, zones)
+			event.AllDay = property.Params["VALUE"] == "DATE" || len(property.Value) == len(icalDateLayout)
+		case "DTEND":
+			event.End, err = resolveICalTime(property, zones)
+			hasEnd = true
+		case "DURATION":
+			duration, err = ParseICalDuration(property.Value)
+			hasDuration = true
+		case "RRULE":
+			event.RRule = property.Value
+		case "EXDATE":
+			for _, value := range strings.Split(property.Value, ",") {
+				single := property
+				single.Value = value
+				exdate, exErr := resolveICalTime(single, zones)
+				if exErr != nil {
+					err = exErr
+					break
+				}
+				event.ExDates = append(event.ExDates, exdate)
+			}
+		}
+		if err != nil && invalidErr == nil {
+			invalidName, invalidErr = property.Name, err
+		}
+	}
+	switch {
+	case invalidName != "":
+		return event, fmt.Errorf("event %q: invalid %s: %v", event.UID, invalidName, invalidErr)
+	case invalidErr != nil:
+		return event, fmt.Errorf("event %q: %v", event.UID, invalidErr)
+	}
+
+	if event.Start.IsZero() {
+		return event, fmt.Errorf("event %q: missing DTSTART", event.UID)
+	}
+	switch {
+	case hasEnd:
+	case hasDuration:
+		event.End = event.Start.Add(duration)
+	case event.AllDay:
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.End = event.Start
+	}
+	return event, nil
+}
+
+// resolveICalTime parses a DATE or DATE-TIME property value in the zone named by its TZID.
+func resolveICalTime(property icalProperty, zones map[string]*icalTimeZone) (time.Time, error) {
+	value := strings.TrimSpace(property.Value)
+	tzid := property.Params["TZID"]
+	if tzid == "" || strings.HasSuffix(value, "Z") {
+		return ParseICalTime(value, time.UTC)
+	}
+
+	if loc, err := loadICalLocation(tzid); err == nil {
+		return ParseICalTime(value, loc)
+	}
+	zone, ok := zones[tzid]
+	if !ok {
+		return time.Time{}, fmt.Errorf("unknown time zone %q", tzid)
+	}
+	if zone.err != nil {
+		return time.Time{}, zone.err
+	}
+
+	local, err := ParseICalTime(value, time.UTC)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return zone.resolve(local), nil
+}
+
+// loadICalLocation loads an IANA zone from a TZID, also accepting the path-prefixed
+// names some clients produce, such as "/mozilla.org/20050126_1/Europe/Berlin".
+func loadICalLocation(tzid string) (*time.Location, error) {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc, nil
+	}
+	parts := strings.Split(strings.Trim(tzid, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		if loc, err := time.LoadLocation(strings.Join(parts[i:], "/")); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown time zone %q", tzid)
+}
+
+var icalDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseICalDuration parses an iCalendar DURATION value such as "PT1H30M" or "P1D".
+func ParseICalDuration(value string) (time.Duration, error) {
+	match := icalDurationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || value == "P" || strings.HasSuffix(value, "T") {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// UnescapeICalText reverses EscapeICalText.
+func UnescapeICalText(value string) string {
+	return strings.NewReplacer(
+		`\\`, `\`,
+		`\;`, ";",
+		`\,`, ",",
+		`\n`, "\n",
+		`\N`, "\n",
+	).Replace(value)
+}
+
+// icalTimeZone is a VTIMEZONE definition with at most one standard and one daylight rule,
+// which covers the yearly-recurring zones calendar clients export.
+type icalTimeZone struct {
+	id       string
+	standard *icalZoneRule
+	daylight *icalZoneRule
+	err      error // Why the definition cannot be used, if it is malformed
+}
+
+// icalZoneRule is a STANDARD or DAYLIGHT observance.
+type icalZoneRule struct {
+	name       string
+	offsetFrom int // Seconds east of UTC before the transition
+	offsetTo   int // Seconds east of UTC after the transition
+	start      time.Time
+	month      time.Month
+	ordinal    int // Week of the month of the transition, negative counts from the end
+	weekday    time.Weekday
+	recurring  bool
+}
+
+var icalByDayPattern = regexp.MustCompile(`^([+-]?\d)?(MO|TU|WE|TH|FR|SA|SU)$`)
+
+// parseICalTimeZone reads a VTIMEZONE component, keeping its latest observances. The zone is
+// returned with its TZID even when the definition is malformed.
+func parseICalTimeZone(component *icalComponent) (*icalTimeZone, error) {
+	zone := &icalTimeZone{}
+	for _, property := range component.Properties {
+		if property.Name == "TZID" {
+			zone.id = property.Value
+		}
+	}
+	if zone.id == "" {
+		return zone, fmt.Errorf("VTIMEZONE without TZID")
+	}
+	if component.err != nil {
+		return zone, fmt.Errorf("time zone %q: %v", zone.id, component.err)
+	}
+
+	for _, child := range component.Children {
+		if child.Name != "STANDARD" && child.Name != "DAYLIGHT" {
+			continue
+		}
+
+		if child.err != nil {
+			return zone, fmt.Errorf("time zone %q: %v", zone.id, child.err)
+		}
+		rule := &icalZoneRule{name: child.Name}
+		for _, property := range child.Properties {
+			var err error
+			switch property.Name {
+			case "TZOFFSETFROM":
+				rule.offsetFrom, err = parseICalOffset(property.Value)
+			case "TZOFFSETTO":
+				rule.offsetTo, err = parseICalOffset(property.Value)
+			case "DTSTART":
+				rule.start, err = ParseICalTime(property.Value, time.UTC)
+			case "RRULE":
+				err = rule.parseRecurrence(property.Value)
+			}
+			if err != nil {
+				return zone, fmt.Errorf("time zone %q: invalid %s: %v", zone.id, property.Name, err)
+			}
+		}
+
+		// Keep the most recent observance of each kind
+		current := &zone.standard
+		if child.Name == "DAYLIGHT" {
+			current = &zone.daylight
+		}
+		if *current == nil || rule.start.After((*current).start) {
+			*current = rule
+		}
+	}
+
+	if zone.standard == nil && zone.daylight == nil {
+		return zone, fmt.Errorf("time zone %q has no observances", zone.id)
+	}
+	return zone, nil
+}
+
+// parseRecurrence reads the yearly transition rule of an observance.
+func (r *icalZoneRule) parseRecurrence(value string) error {
+	for _, part := range strings.Split(value, ";") {
+		key, val, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			if strings.ToUpper(val) != "YEARLY" {
+				return fmt.Errorf("unsupported frequency %q", val)
+			}
+		case "BYMONTH":
+			month, err := strconv.Atoi(val)
+			if err != nil || month < 1 || month > 12 {
+				return fmt.Errorf("invalid month %q", val)
+			}
+			r.month = time.Month(month)
+		case "BYDAY":
+			match := icalByDayPattern.FindStringSubmatch(strings.ToUpper(val))
+			if match == nil {
+				return fmt.Errorf("unsupported day %q", val)
+			}
+			r.ordinal = 1
+			if match[1] != "" {
+				r.ordinal, _ = strconv.Atoi(match[1])
+			}
+			r.weekday = rruleWeekdays[match[2]]
+		}
+	}
+	r.recurring = r.month != 0 && r.ordinal != 0
+	return nil
+}
+
+// transition returns the UTC instant of the observance in the given year.
+func (r *icalZoneRule) transition(year int) time.Time {
+	hour, min, sec := r.start.Clock()
+	if !r.recurring {
+		return r.start.Add(-time.Duration(r.offsetFrom) * time.Second)
+	}
+
+	var day time.Time
+	if r.ordinal > 0 {
+		first := time.Date(year, r.month, 1, hour, min, sec, 0, time.UTC)
+		offset := (int(r.weekday) - int(first.Weekday()) + 7) % 7
+		day = first.AddDate(0, 0, offset+(r.ordinal-1)*7)
+	} else {
+		last := time.Date(year, r.month+1, 0, hour, min, sec, 0, time.UTC)
+		offset := (int(last.Weekday()) - int(r.weekday) + 7) % 7
+		day = last.AddDate(0, 0, -offset+(r.ordinal+1)*7)
+	}
+	// Transition times are written in the local time in force before the transition
+	return day.Add(-time.Duration(r.offsetFrom) * time.Second)
+}
+
+// resolve interprets a wall clock time, given with a UTC location, in the zone.
+func (z *icalTimeZone) resolve(local time.Time) time.Time {
+	rule := z.standard
+	if rule == nil || (z.daylight != nil && z.inDaylight(local)) {
+		rule = z.daylight
+	}
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(),
+		local.Nanosecond(), time.FixedZone(rule.name, rule.offsetTo))
+}
+
+// inDaylight reports whether daylight time is in force at the wall clock time.
+func (z *icalTimeZone) inDaylight(local time.Time) bool {
+	if z.standard == nil {
+		return true
+	}
+	// Compare instants by reading the wall clock in standard time
+	instant := local.Add(-time.Duration(z.standard.offsetTo) * time.Second)
+	daylightStart := z.daylight.transition(local.Year())
+	standardStart := z.standard.transition(local.Year())
+	if daylightStart.Before(standardStart) {
+		return !instant.Before(daylightStart) && instant.Before(standardStart)
+	}
+	// Southern hemisphere zones keep daylight time across the new year
+	return !instant.Before(daylightStart) || instant.Before(standardStart)
+}
+
+// parseICalOffset parses a UTC offset such as "+0100" or "-053000" into seconds.
+func parseICalOffset(value string) (int, error) {
+	if len(value) != 5 && len(value) != 7 {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	sign := 1
+	switch value[0] {
+	case '+':
+	case '-':
+		sign = -1
+	default:
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+
+	hours, err1 := strconv.Atoi(value[1:3])
+	minutes, err2 := strconv.Atoi(value[3:5])
+	seconds := 0
+	var err3 error
+	if len(value) == 7 {
+		seconds, err3 = strconv.Atoi(value[5:7])
+	}
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, fmt.Errorf("invalid offset %q", value)
+	}
+	return sign * (hours*3600 + minutes*60 + seconds), nil
+}
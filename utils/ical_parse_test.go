@@ -0,0 +1,470 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCalendar wraps content lines in a VCALENDAR with a custom Central European zone,
+// whose TZID is not an IANA name so it resolves through its VTIMEZONE.
+func testCalendar(lines ...string) string {
+	return strings.Join(append([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VTIMEZONE",
+		"TZID:Custom/Central",
+		"BEGIN:DAYLIGHT",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+0200",
+		"DTSTART:19700329T020000",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0100",
+		"DTSTART:19701025T030000",
+		"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"BEGIN:VTIMEZONE",
+		"TZID:Custom/Broken",
+		"BEGIN:STANDARD",
+		"TZOFFSETFROM:+0100",
+		"TZOFFSETTO:+01",
+		"DTSTART:19700101T000000",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+	}, append(lines, "END:VCALENDAR")...), "\r\n")
+}
+
+func TestParseICalendarEvents(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		lines []string
+		want  ParsedICalEvent
+		err   string
+	}{
+		{
+			name: "utc times",
+			lines: []string{
+				"UID:utc",
+				"SUMMARY:Team sync\\, weekly",
+				"STATUS:confirmed",
+				"DTSTART:20250301T100000Z",
+				"DTEND:20250301T110000Z",
+			},
+			want: ParsedICalEvent{
+				UID:     "utc",
+				Summary: "Team sync, weekly",
+				Status:  "CONFIRMED",
+				Start:   time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+				End:     time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "folded line",
+			lines: []string{
+				"UID:folded",
+				"SUMMARY:Quarterly",
+				"  planning",
+				"DTSTART:20250301T100000Z",
+			},
+			want: ParsedICalEvent{
+				UID:     "folded",
+				Summary: "Quarterly planning",
+				Start:   time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+				End:     time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "iana zone with duration",
+			lines: []string{
+				"UID:iana",
+				"DTSTART;TZID=Europe/Berlin:20250715T100000",
+				"DURATION:PT1H30M",
+			},
+			want: ParsedICalEvent{
+				UID:   "iana",
+				Start: time.Date(2025, 7, 15, 10, 0, 0, 0, berlin),
+				End:   time.Date(2025, 7, 15, 11, 30, 0, 0, berlin),
+			},
+		},
+		{
+			name: "path-prefixed iana zone",
+			lines: []string{
+				"UID:prefixed",
+				"DTSTART;TZID=/mozilla.org/20050126_1/Europe/Berlin:20250115T100000",
+			},
+			want: ParsedICalEvent{
+				UID:   "prefixed",
+				Start: time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "vtimezone in summer",
+			lines: []string{
+				"UID:summer",
+				"DTSTART;TZID=Custom/Central:20250715T100000",
+				"DTEND;TZID=Custom/Central:20250715T110000",
+			},
+			want: ParsedICalEvent{
+				UID:   "summer",
+				Start: time.Date(2025, 7, 15, 8, 0, 0, 0, time.UTC),
+				End:   time.Date(2025, 7, 15, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "vtimezone in winter",
+			lines: []string{
+				"UID:winter",
+				"DTSTART;TZID=\"Custom/Central\":20251215T100000",
+			},
+			want: ParsedICalEvent{
+				UID:   "winter",
+				Start: time.Date(2025, 12, 15, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2025, 12, 15, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "all day",
+			lines: []string{
+				"UID:allday",
+				"DTSTART;VALUE=DATE:20250301",
+			},
+			want: ParsedICalEvent{
+				UID:    "allday",
+				Start:  time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+				End:    time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC),
+				AllDay: true,
+			},
+		},
+		{
+			name: "recurrence with exclusions",
+			lines: []string{
+				"UID:recurring",
+				"DTSTART;TZID=Custom/Central:20250303T100000",
+				"RRULE:FREQ=WEEKLY;COUNT=4",
+				"EXDATE;TZID=Custom/Central:20250310T100000,20250331T100000",
+			},
+			want: ParsedICalEvent{
+				UID:   "recurring",
+				Start: time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+				End:   time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+				RRule: "FREQ=WEEKLY;COUNT=4",
+				ExDates: []time.Time{
+					time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC),
+					time.Date(2025, 3, 31, 8, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			name: "invalid end",
+			lines: []string{
+				"UID:bad-end",
+				"DTSTART:20250301T100000Z",
+				"DTEND:tomorrow",
+			},
+			want: ParsedICalEvent{UID: "bad-end", Start: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},
+			err:  `event "bad-end": invalid DTEND`,
+		},
+		{
+			name: "invalid duration",
+			lines: []string{
+				"UID:bad-duration",
+				"DTSTART:20250301T100000Z",
+				"DURATION:1 hour",
+			},
+			want: ParsedICalEvent{UID: "bad-duration", Start: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},
+			err:  "invalid DURATION",
+		},
+		{
+			name: "malformed line read past",
+			lines: []string{
+				"DTSTART:20250301T100000Z",
+				"NOT A PROPERTY",
+				"UID:malformed",
+			},
+			want: ParsedICalEvent{UID: "malformed", Start: time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)},
+			err:  "invalid content line",
+		},
+		{
+			name: "unknown zone",
+			lines: []string{
+				"UID:unknown-zone",
+				"DTSTART;TZID=Nowhere/Special:20250301T100000",
+			},
+			want: ParsedICalEvent{UID: "unknown-zone"},
+			err:  `unknown time zone "Nowhere/Special"`,
+		},
+		{
+			name: "broken vtimezone",
+			lines: []string{
+				"UID:broken-zone",
+				"DTSTART;TZID=Custom/Broken:20250301T100000",
+			},
+			want: ParsedICalEvent{UID: "broken-zone"},
+			err:  "invalid TZOFFSETTO",
+		},
+		{
+			name:  "missing start",
+			lines: []string{"UID:no-start", "SUMMARY:Nothing"},
+			want:  ParsedICalEvent{UID: "no-start", Summary: "Nothing"},
+			err:   "missing DTSTART",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append(append([]string{"BEGIN:VEVENT"}, tt.lines...), "END:VEVENT")
+			events, err := ParseICalendar(testCalendar(lines...))
+			if err != nil {
+				t.Fatalf("ParseICalendar failed: %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("ParseICalendar returned %d events, want 1", len(events))
+			}
+			got := events[0]
+
+			switch {
+			case tt.err == "" && got.Err != nil:
+				t.Fatalf("event error = %v, want none", got.Err)
+			case tt.err != "" && (got.Err == nil || !strings.Contains(got.Err.Error(), tt.err)):
+				t.Fatalf("event error = %v, want %q", got.Err, tt.err)
+			}
+			if got.UID != tt.want.UID || got.Summary != tt.want.Summary || got.Status != tt.want.Status ||
+				got.AllDay != tt.want.AllDay || got.RRule != tt.want.RRule {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+			if !got.Start.Equal(tt.want.Start) || !got.End.Equal(tt.want.End) {
+				t.Errorf("event runs %v to %v, want %v to %v", got.Start, got.End, tt.want.Start, tt.want.End)
+			}
+			if !equalTimes(got.ExDates, tt.want.ExDates) {
+				t.Errorf("exdates = %v, want %v", got.ExDates, tt.want.ExDates)
+			}
+		})
+	}
+}
+
+func TestParseICalendarDocumentErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "no VCALENDAR found"},
+		{"unterminated", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nEND:VEVENT", "unterminated VCALENDAR component"},
+		{"mismatched end", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR", "line 3: unexpected END:VCALENDAR"},
+		{"stray end", "END:VCALENDAR", "line 1: unexpected END:VCALENDAR"},
+		{"not icalendar", "hello world", "line 1: invalid content line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseICalendar(tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseICalendar error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseICalendarKeepsOtherEvents(t *testing.T) {
+	events, err := ParseICalendar(testCalendar(
+		"BEGIN:VEVENT", "UID:first", "DTSTART:20250301T100000Z", "END:VEVENT",
+		"BEGIN:VEVENT", "UID:second", "DTSTART:someday", "END:VEVENT",
+		"BEGIN:VEVENT", "UID:third", "DTSTART:20250302T100000Z", "END:VEVENT",
+	))
+	if err != nil {
+		t.Fatalf("ParseICalendar failed: %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("ParseICalendar returned %d events, want 3", len(events))
+	}
+	for i, wantErr := range []bool{false, true, false} {
+		if (events[i].Err != nil) != wantErr {
+			t.Errorf("event %q error = %v, want error %v", events[i].UID, events[i].Err, wantErr)
+		}
+	}
+}
+
+// testZoneRule builds an observance from its offsets, DTSTART and RRULE.
+func testZoneRule(t *testing.T, from, to, start, rrule string) *icalZoneRule {
+	t.Helper()
+	rule := &icalZoneRule{}
+	var err error
+	if rule.offsetFrom, err = parseICalOffset(from); err != nil {
+		t.Fatal(err)
+	}
+	if rule.offsetTo, err = parseICalOffset(to); err != nil {
+		t.Fatal(err)
+	}
+	if rule.start, err = ParseICalTime(start, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if rrule != "" {
+		if err := rule.parseRecurrence(rrule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rule
+}
+
+func TestICalZoneRuleTransition(t *testing.T) {
+	tests := []struct {
+		name  string
+		from  string
+		to    string
+		start string
+		rrule string
+		year  int
+		want  time.Time
+	}{
+		{
+			name: "last sunday in march", from: "+0100", to: "+0200",
+			start: "19700329T020000", rrule: "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", year: 2025,
+			want: time.Date(2025, 3, 30, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "last sunday in october", from: "+0200", to: "+0100",
+			start: "19701025T030000", rrule: "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU", year: 2025,
+			want: time.Date(2025, 10, 26, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "last sunday on the last day of the month", from: "+0100", to: "+0200",
+			start: "19700329T020000", rrule: "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU", year: 2024,
+			want: time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "second sunday in march", from: "-0500", to: "-0400",
+			start: "20070311T020000", rrule: "FREQ=YEARLY;BYMONTH=3;BYDAY=2SU", year: 2025,
+			want: time.Date(2025, 3, 9, 7, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "first sunday in november", from: "-0400", to: "-0500",
+			start: "20071104T020000", rrule: "FREQ=YEARLY;BYMONTH=11;BYDAY=1SU", year: 2025,
+			want: time.Date(2025, 11, 2, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "first sunday on the first day of the month", from: "-0400", to: "-0500",
+			start: "20071104T020000", rrule: "FREQ=YEARLY;BYMONTH=11;BYDAY=SU", year: 2026,
+			want: time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "one-off observance", from: "+1100", to: "+1000",
+			start: "20250406T030000", year: 2025,
+			want: time.Date(2025, 4, 5, 16, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := testZoneRule(t, tt.from, tt.to, tt.start, tt.rrule)
+			if got := rule.transition(tt.year); !got.Equal(tt.want) {
+				t.Errorf("transition(%d) = %v, want %v", tt.year, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestICalTimeZoneResolve(t *testing.T) {
+	central := &icalTimeZone{
+		standard: testZoneRule(t, "+0200", "+0100", "19701025T030000", "FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU"),
+		daylight: testZoneRule(t, "+0100", "+0200", "19700329T020000", "FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU"),
+	}
+	southern := &icalTimeZone{
+		standard: testZoneRule(t, "+1100", "+1000", "20080406T030000", "FREQ=YEARLY;BYMONTH=4;BYDAY=1SU"),
+		daylight: testZoneRule(t, "+1000", "+1100", "20081005T020000", "FREQ=YEARLY;BYMONTH=10;BYDAY=1SU"),
+	}
+	fixed := &icalTimeZone{
+		standard: testZoneRule(t, "+0530", "+0530", "19700101T000000", ""),
+	}
+
+	tests := []struct {
+		name  string
+		zone  *icalTimeZone
+		local time.Time
+		want  time.Time
+	}{
+		{"winter", central, time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 1, 15, 9, 0, 0, 0, time.UTC)},
+		{"summer", central, time.Date(2025, 7, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 7, 15, 8, 0, 0, 0, time.UTC)},
+		{"before spring forward", central, time.Date(2025, 3, 30, 1, 59, 0, 0, time.UTC), time.Date(2025, 3, 30, 0, 59, 0, 0, time.UTC)},
+		{"after spring forward", central, time.Date(2025, 3, 30, 3, 0, 0, 0, time.UTC), time.Date(2025, 3, 30, 1, 0, 0, 0, time.UTC)},
+		{"after fall back", central, time.Date(2025, 10, 26, 4, 0, 0, 0, time.UTC), time.Date(2025, 10, 26, 3, 0, 0, 0, time.UTC)},
+		{"southern summer", southern, time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 1, 14, 23, 0, 0, 0, time.UTC)},
+		{"southern winter", southern, time.Date(2025, 7, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 7, 15, 0, 0, 0, 0, time.UTC)},
+		{"fixed offset", fixed, time.Date(2025, 7, 15, 10, 0, 0, 0, time.UTC), time.Date(2025, 7, 15, 4, 30, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.zone.resolve(tt.local); !got.Equal(tt.want) {
+				t.Errorf("resolve(%v) = %v, want %v", tt.local, got.UTC(), tt.want)
+			}
+		})
+	}
+}
+
+func TestParseICalDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		err   bool
+	}{
+		{value: "PT1H30M", want: 90 * time.Minute},
+		{value: "P1D", want: 24 * time.Hour},
+		{value: "P2W", want: 14 * 24 * time.Hour},
+		{value: "P1DT12H", want: 36 * time.Hour},
+		{value: "PT45S", want: 45 * time.Second},
+		{value: "+PT15M", want: 15 * time.Minute},
+		{value: "-PT15M", want: -15 * time.Minute},
+		{value: "P", err: true},
+		{value: "PT", err: true},
+		{value: "P1DT", err: true},
+		{value: "PT1.5H", err: true},
+		{value: "1H", err: true},
+		{value: "", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseICalDuration(tt.value)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("ParseICalDuration(%q) = %v, want an error", tt.value, got)
+		case !tt.err && err != nil:
+			t.Errorf("ParseICalDuration(%q) failed: %v", tt.value, err)
+		case got != tt.want:
+			t.Errorf("ParseICalDuration(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseICalOffset(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		err   bool
+	}{
+		{value: "+0100", want: 3600},
+		{value: "-0500", want: -5 * 3600},
+		{value: "+0530", want: 5*3600 + 30*60},
+		{value: "-003015", want: -(30*60 + 15)},
+		{value: "+0000", want: 0},
+		{value: "0100", err: true},
+		{value: "+01", err: true},
+		{value: "+01:00", err: true},
+		{value: "+ab00", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseICalOffset(tt.value)
+		switch {
+		case tt.err && err == nil:
+			t.Errorf("parseICalOffset(%q) = %d, want an error", tt.value, got)
+		case !tt.err && err != nil:
+			t.Errorf("parseICalOffset(%q) failed: %v", tt.value, err)
+		case got != tt.want:
+			t.Errorf("parseICalOffset(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
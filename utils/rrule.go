@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxOccurrences caps how many instances a single recurrence rule may expand to
+	maxOccurrences = 366
+	// openEndedHorizon bounds rules without COUNT or UNTIL
+	openEndedHorizon = 1 // years
+	// maxPeriods stops rules whose BYDAY never matches
+	maxPeriods = 5000
+)
+
+var rruleWeekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// RRule is the subset of an RFC 5545 recurrence rule supported for appointments:
+// FREQ (DAILY, WEEKLY, MONTHLY), INTERVAL, BYDAY (without ordinals), COUNT and UNTIL.
+type RRule struct {
+	Freq     string
+	Interval int
+	ByDay    []time.Weekday
+	Count    int
+	Until    time.Time
+}
+
+// ParseRRule parses an RRULE value such as "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10". A leading
+// "RRULE:" is accepted. Floating and date-only UNTIL values are read in loc.
+func ParseRRule(value string, loc *time.Location) (*RRule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, fmt.Errorf("empty recurrence rule")
+	}
+
+	rule := &RRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, val, ok := strings.Cut(part, "=")
+		if !ok || val == "" {
+			return nil, fmt.Errorf("invalid recurrence rule part %q", part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(val)
+			if rule.Freq != "DAILY" && rule.Freq != "WEEKLY" && rule.Freq != "MONTHLY" {
+				return nil, fmt.Errorf("unsupported recurrence frequency %q", val)
+			}
+		case "INTERVAL":
+			interval, err := strconv.Atoi(val)
+			if err != nil || interval < 1 {
+				return nil, fmt.Errorf("invalid recurrence interval %q", val)
+			}
+			rule.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(val)
+			if err != nil || count < 1 {
+				return nil, fmt.Errorf("invalid recurrence count %q", val)
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := ParseICalTime(val, loc)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recurrence until %q", val)
+			}
+			// A date-only UNTIL includes the whole day
+			if len(val) == len(icalDateLayout) {
+				until = until.AddDate(0, 0, 1).Add(-time.Nanosecond)
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, day := range strings.Split(strings.ToUpper(val), ",") {
+				weekday, ok := rruleWeekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("unsupported recurrence day %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "WKST":
+			if strings.ToUpper(val) != "MO" {
+				return nil, fmt.Errorf("unsupported recurrence week start %q", val)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule part %q", key)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("recurrence rule requires FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, fmt.Errorf("recurrence rule cannot have both COUNT and UNTIL")
+	}
+	if rule.Freq == "MONTHLY" && len(rule.ByDay) > 0 {
+		return nil, fmt.Errorf("BYDAY is not supported for monthly recurrence")
+	}
+	return rule, nil
+}
+
+// String formats the rule back into its RRULE value.
+func (r *RRule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, 0, len(r.ByDay))
+		for _, weekday := range r.ByDay {
+			for name, day := range rruleWeekdays {
+				if day == weekday {
+					days = append(days, name)
+				}
+			}
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(icalUTCLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences expands the rule from dtstart and removes the excluded start times. Instances keep the wall clock time of dtstart in its
+// location, so they follow daylight saving changes. Rules without COUNT or UNTIL stop after a year.
+func (r *RRule) Occurrences(dtstart time.Time, exdates []time.Time) ([]time.Time, error) {
+	until := r.Until
+	if r.Count == 0 && until.IsZero() {
+		until = dtstart.AddDate(openEndedHorizon, 0, 0)
+	}
+
+	excluded := make(map[int64]bool, len(exdates))
+	for _, exdate := range exdates {
+		excluded[exdate.Unix()] = true
+	}
+
+	var occurrences []time.Time
+	generated := 0
+	for period := 0; period < maxPeriods; period++ {
+		candidates := r.periodCandidates(dtstart, period)
+		if candidates == nil {
+			break
+		}
+		for _, candidate := range candidates {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if !until.IsZero() && candidate.After(until) {
+				return occurrences, nil
+			}
+			// COUNT applies before exclusions, as in RFC 5545
+			generated++
+			if !excluded[candidate.Unix()] {
+				occurrences = append(occurrences, candidate)
+			}
+			if len(occurrences) > maxOccurrences {
+				return nil, fmt.Errorf("recurrence produces more than %d occurrences", maxOccurrences)
+			}
+			if r.Count > 0 && generated >= r.Count {
+				return occurrences, nil
+			}
+		}
+	}
+	return occurrences, nil
+}
+
+// periodCandidates returns the candidate instances of the given period, in order.
+func (r *RRule) periodCandidates(dtstart time.Time, period int) []time.Time {
+	year, month, day := dtstart.Date()
+	hour, min, sec := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, hour, min, sec, dtstart.Nanosecond(), loc)
+	}
+
+	switch r.Freq {
+	case "DAILY":
+		candidate := at(year, month, day+period*r.Interval)
+		if len(r.ByDay) > 0 && !containsWeekday(r.ByDay, candidate.Weekday()) {
+			return []time.Time{}
+		}
+		return []time.Time{candidate}
+	case "WEEKLY":
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []time.Weekday{dtstart.Weekday()}
+		}
+		// Weeks start on Monday
+		weekStart := day - (int(dtstart.Weekday())+6)%7 + period*r.Interval*7
+		candidates := make([]time.Time, 0, len(days))
+		for _, weekday := range days {
+			candidates = append(candidates, at(year, month, weekStart+(int(weekday)+6)%7))
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+		return candidates
+	case "MONTHLY":
+		candidate := at(year, month+time.Month(period*r.Interval), day)
+		// Months without this day are skipped
+		if candidate.Day() != day {
+			return []time.Time{}
+		}
+		return []time.Time{candidate}
+	}
+	return nil
+}
+
+func containsWeekday(days []time.Weekday, weekday time.Weekday) bool {
+	for _, day := range days {
+		if day == weekday {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRRuleErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"empty", "", "empty recurrence rule"},
+		{"missing freq", "COUNT=3", "recurrence rule requires FREQ"},
+		{"yearly", "FREQ=YEARLY", "unsupported recurrence frequency"},
+		{"zero interval", "FREQ=DAILY;INTERVAL=0", "invalid recurrence interval"},
+		{"zero count", "FREQ=DAILY;COUNT=0", "invalid recurrence count"},
+		{"bad until", "FREQ=DAILY;UNTIL=tomorrow", "invalid recurrence until"},
+		{"bad day", "FREQ=WEEKLY;BYDAY=XX", "unsupported recurrence day"},
+		{"ordinal day", "FREQ=WEEKLY;BYDAY=1MO", "unsupported recurrence day"},
+		{"count and until", "FREQ=DAILY;COUNT=3;UNTIL=20250310T000000Z", "cannot have both COUNT and UNTIL"},
+		{"monthly byday", "FREQ=MONTHLY;BYDAY=MO", "BYDAY is not supported for monthly recurrence"},
+		{"sunday week start", "FREQ=WEEKLY;WKST=SU", "unsupported recurrence week start"},
+		{"unknown part", "FREQ=DAILY;BYHOUR=9", "unsupported recurrence rule part"},
+		{"part without value", "FREQ=DAILY;COUNT", "invalid recurrence rule part"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRRule(tt.value, time.UTC)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("ParseRRule(%q) error = %v, want %q", tt.value, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRRuleString(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"RRULE:FREQ=DAILY;COUNT=5", "FREQ=DAILY;COUNT=5"},
+		{"freq=weekly;interval=2;byday=mo,fr", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR"},
+		{"FREQ=MONTHLY;UNTIL=20250601T090000Z", "FREQ=MONTHLY;UNTIL=20250601T090000Z"},
+		{"FREQ=DAILY;INTERVAL=1;WKST=MO", "FREQ=DAILY"},
+	}
+	for _, tt := range tests {
+		rule, err := ParseRRule(tt.value, time.UTC)
+		if err != nil {
+			t.Fatalf("ParseRRule(%q) failed: %v", tt.value, err)
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseRRule(%q).String() = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRRuleOccurrences(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	// Monday 3 March 2025, 10:00 UTC
+	monday := time.Date(2025, 3, 3, 10, 0, 0, 0, time.UTC)
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 10, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		exdates []time.Time
+		want    []time.Time
+	}{
+		{
+			name:    "daily count",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(4), day(5)},
+		},
+		{
+			name:    "daily interval",
+			rule:    "FREQ=DAILY;INTERVAL=3;COUNT=3",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(6), day(9)},
+		},
+		{
+			name:    "until is inclusive",
+			rule:    "FREQ=DAILY;UNTIL=20250305T100000Z",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(4), day(5)},
+		},
+		{
+			name:    "date-only until covers the whole day",
+			rule:    "FREQ=DAILY;UNTIL=20250305",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(4), day(5)},
+		},
+		{
+			name:    "daily restricted to weekend days",
+			rule:    "FREQ=DAILY;BYDAY=SA,SU;COUNT=3",
+			dtstart: monday,
+			want:    []time.Time{day(8), day(9), day(15)},
+		},
+		{
+			name:    "weekly on the start day",
+			rule:    "FREQ=WEEKLY;COUNT=3",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(10), day(17)},
+		},
+		{
+			name:    "weekly by day in week order",
+			rule:    "FREQ=WEEKLY;BYDAY=WE,MO;COUNT=4",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(5), day(10), day(12)},
+		},
+		{
+			name:    "weekly by day skips days before the start",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=3",
+			dtstart: day(5),
+			want:    []time.Time{day(6), day(10), day(13)},
+		},
+		{
+			name:    "biweekly",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;COUNT=3",
+			dtstart: monday,
+			want:    []time.Time{day(3), day(17), day(31)},
+		},
+		{
+			name:    "count applies before exclusions",
+			rule:    "FREQ=DAILY;COUNT=4",
+			dtstart: monday,
+			exdates: []time.Time{day(4)},
+			want:    []time.Time{day(3), day(5), day(6)},
+		},
+		{
+			name:    "exclusions within until",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20250312T235959Z",
+			dtstart: monday,
+			exdates: []time.Time{day(5), day(10)},
+			want:    []time.Time{day(3), day(12)},
+		},
+		{
+			name:    "monthly skips months without the day",
+			rule:    "FREQ=MONTHLY;COUNT=3",
+			dtstart: time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC),
+			want: []time.Time{
+				time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 31, 9, 0, 0, 0, time.UTC),
+				time.Date(2025, 5, 31, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name:    "wall clock kept across daylight saving",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: time.Date(2025, 3, 29, 10, 0, 0, 0, berlin),
+			want: []time.Time{
+				time.Date(2025, 3, 29, 9, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 30, 8, 0, 0, 0, time.UTC),
+				time.Date(2025, 3, 31, 8, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRRule(tt.rule, tt.dtstart.Location())
+			if err != nil {
+				t.Fatalf("ParseRRule(%q) failed: %v", tt.rule, err)
+			}
+			got, err := rule.Occurrences(tt.dtstart, tt.exdates)
+			if err != nil {
+				t.Fatalf("Occurrences failed: %v", err)
+			}
+			if !equalTimes(got, tt.want) {
+				t.Errorf("Occurrences = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRRuleOccurrencesLimits(t *testing.T) {
+	start := time.Date(2025, 1, 1, 9, 0, 0, 0, time.UTC)
+
+	open, _ := ParseRRule("FREQ=WEEKLY", time.UTC)
+	occurrences, err := open.Occurrences(start, nil)
+	if err != nil {
+		t.Fatalf("open-ended rule failed: %v", err)
+	}
+	if last := occurrences[len(occurrences)-1]; last.After(start.AddDate(1, 0, 0)) || len(occurrences) != 53 {
+		t.Errorf("open-ended weekly rule gave %d occurrences ending %v, want 53 within a year", len(occurrences), last)
+	}
+
+	long, _ := ParseRRule("FREQ=DAILY;COUNT=400", time.UTC)
+	if _, err := long.Occurrences(start, nil); err == nil {
+		t.Error("rule beyond the occurrence limit was accepted")
+	}
+}
+
+// equalTimes reports whether both lists hold the same instants in order.
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
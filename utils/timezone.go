@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoadTimeZone loads an IANA time zone by name. An empty name is UTC; the server's
+// "Local" zone is rejected since it differs between deployments.
+func LoadTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if name == "Local" {
+		return nil, fmt.Errorf("invalid time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q", name)
+	}
+	return loc, nil
+}
+
+// ShiftWallClock moves t by the given amount of wall clock time in loc, so that a one hour
+// shift keeps a 9:00 appointment at 10:00 local time across daylight saving changes.
+func ShiftWallClock(t time.Time, shift time.Duration, loc *time.Location) time.Time {
+	local := t.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(),
+		local.Nanosecond()+int(shift), loc)
+}
+
+// WallClockDiff returns the difference between the wall clock times of a and b in loc.
+func WallClockDiff(a, b time.Time, loc *time.Location) time.Duration {
+	wall := func(t time.Time) time.Time {
+		local := t.In(loc)
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), local.Minute(), local.Second(),
+			local.Nanosecond(), time.UTC)
+	}
+	return wall(a).Sub(wall(b))
+}
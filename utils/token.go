@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// secretTokenBytes is the amount of randomness in generated secret tokens
+const secretTokenBytes = 32
+
+// GenerateSecretToken creates a random URL-safe token.
+func GenerateSecretToken() (string, error) {
+	buf := make([]byte, secretTokenBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// HashSecretToken returns the SHA-256 hex digest under which a secret token is stored.
+func HashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}